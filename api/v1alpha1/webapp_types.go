@@ -23,14 +23,257 @@ import (
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
 // NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
 
+const (
+	// RollbackAnnotation requests a rollback, it accepts the same values as WebappSpec.RollbackTo
+	RollbackAnnotation = "webapp.simpletest.com/rollback"
+	// RollbackPrevious targets the revision deployed before the current one
+	RollbackPrevious = "previous"
+	// MaxHistoryLength is the number of deployments kept in the status history
+	MaxHistoryLength = 10
+	// DryRunAnnotation set to "true" only plans the deployment, like WebappSpec.DryRun
+	DryRunAnnotation = "webapp.simpletest.com/dry-run"
+	// ApprovedVersionAnnotation approves the deployment of a version under the Manual approval policy
+	ApprovedVersionAnnotation = "webapp.simpletest.com/approved-version"
+	// SlotBlue and SlotGreen are the names of the blue/green slots
+	SlotBlue  = "blue"
+	SlotGreen = "green"
+	// MaxPlannedFiles is the number of files listed in the status plan
+	MaxPlannedFiles = 50
+)
+
+// ApprovalPolicy tells whether a new version is deployed right away or waits for an approval
+// +kubebuilder:validation:Enum=Automatic;Manual
+type ApprovalPolicy string
+
+const (
+	ApprovalPolicyAutomatic ApprovalPolicy = "Automatic"
+	ApprovalPolicyManual    ApprovalPolicy = "Manual"
+)
+
+// Weekday is a day of the week a deployment window applies to
+// +kubebuilder:validation:Enum=Monday;Tuesday;Wednesday;Thursday;Friday;Saturday;Sunday
+type Weekday string
+
+// DeploymentWindow is a recurring time range during which new versions can be deployed
+type DeploymentWindow struct {
+	// Days the window applies to, every day when empty
+	// +kubebuilder:validation:Optional
+	Days []Weekday `json:"days,omitempty"`
+	// Start of the window, in HH:MM format
+	// +kubebuilder:validation:Pattern=`^([01][0-9]|2[0-3]):[0-5][0-9]$`
+	Start string `json:"start"`
+	// End of the window, in HH:MM format, the window ends the next day when it is before the start
+	// +kubebuilder:validation:Pattern=`^([01][0-9]|2[0-3]):[0-5][0-9]$`
+	End string `json:"end"`
+	// TimeZone of the window, as an IANA time zone name
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default:=UTC
+	TimeZone string `json:"timeZone,omitempty"`
+}
+
+// VersionMode tells how the version to deploy is chosen
+// +kubebuilder:validation:Enum=Pinned;Latest
+type VersionMode string
+
+const (
+	// VersionModePinned deploys WebappSpec.VersionToDeploy
+	VersionModePinned VersionMode = "Pinned"
+	// VersionModeLatest deploys the latest package matching the version policy
+	VersionModeLatest VersionMode = "Latest"
+)
+
+// VersionPolicy tracks the packages published in the package container
+type VersionPolicy struct {
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default:=Pinned
+	Mode VersionMode `json:"mode,omitempty"`
+	// Constraint is the semver constraint the tracked versions must satisfy (e.g. "~1.2"), any release when empty
+	// +kubebuilder:validation:Optional
+	Constraint string `json:"constraint,omitempty"`
+	// Channel is the suffix of the tracked versions (e.g. ".master"), the packages of other channels are ignored
+	// +kubebuilder:validation:Optional
+	Channel string `json:"channel,omitempty"`
+	// PollInterval is the delay between two lookups of the package container, defaults to 5 minutes
+	// +kubebuilder:validation:Optional
+	PollInterval *metav1.Duration `json:"pollInterval,omitempty"`
+}
+
+// SlotMode tells whether the blue/green slots are prefixes of the container or containers of the storage account
+// +kubebuilder:validation:Enum=Prefixes;Containers
+type SlotMode string
+
+const (
+	SlotModePrefixes   SlotMode = "Prefixes"
+	SlotModeContainers SlotMode = "Containers"
+)
+
+// SwitchStrategy tells how the traffic is moved to the new active slot
+// +kubebuilder:validation:Enum=Pointer;Hook
+type SwitchStrategy string
+
+const (
+	// SwitchPointer rewrites the file to check at the root of the container to redirect to the active slot prefix
+	SwitchPointer SwitchStrategy = "Pointer"
+	// SwitchHook posts the active slot to HookUrl, which routes the traffic (e.g. by updating a CDN origin)
+	SwitchHook SwitchStrategy = "Hook"
+)
+
+// BlueGreen deploys each version to the idle slot, verifies it then makes it the active slot
+type BlueGreen struct {
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default:=Prefixes
+	Mode SlotMode `json:"mode,omitempty"`
+	// Blue is the prefix or the container of the blue slot
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default:=blue
+	Blue string `json:"blue,omitempty"`
+	// Green is the prefix or the container of the green slot
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default:=green
+	Green string `json:"green,omitempty"`
+	// Switch is the way the traffic is moved to the active slot, Pointer requires the Prefixes mode
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default:=Pointer
+	Switch SwitchStrategy `json:"switch,omitempty"`
+	// HookUrl receives the switch notifications of the Hook strategy
+	// +kubebuilder:validation:Optional
+	HookUrl string `json:"hookUrl,omitempty"`
+}
+
+// VerificationCheck is a request made on the site once a version is deployed
+type VerificationCheck struct {
+	// Path of the request, relative to the verification base URL
+	// +kubebuilder:validation:Required
+	Path string `json:"path"`
+	// ExpectedStatus is the expected response status code
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default:=200
+	ExpectedStatus int `json:"expectedStatus,omitempty"`
+	// Contains is a text the response body must contain
+	// +kubebuilder:validation:Optional
+	Contains string `json:"contains,omitempty"`
+	// VersionField is the field of the JSON response body (e.g. of a version.json file) which must hold the deployed version
+	// +kubebuilder:validation:Optional
+	VersionField string `json:"versionField,omitempty"`
+}
+
+// Verification checks the site once a version is deployed
+type Verification struct {
+	// BaseUrl is the address the checks are made on, the static website endpoint or a custom domain.
+	// Defaults to the Webapp siteUrl.
+	// +kubebuilder:validation:Optional
+	BaseUrl string `json:"baseUrl,omitempty"`
+	// +kubebuilder:validation:MinItems=1
+	Checks []VerificationCheck `json:"checks"`
+	// Retries is the number of times the checks are retried before the deployment fails
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:default:=3
+	Retries int `json:"retries,omitempty"`
+	// RetryInterval is the delay between two attempts, defaults to 10 seconds
+	// +kubebuilder:validation:Optional
+	RetryInterval *metav1.Duration `json:"retryInterval,omitempty"`
+	// Timeout bounds each request, defaults to 10 seconds
+	// +kubebuilder:validation:Optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+	// RollbackOnFailure rolls back to the last deployed revision when the verification fails
+	// +kubebuilder:validation:Optional
+	RollbackOnFailure bool `json:"rollbackOnFailure,omitempty"`
+}
+
+// MarkerStrategy tells how the deployed version is recorded on the target container
+// +kubebuilder:validation:Enum=BlobTag;Metadata;File;MetaTag
+type MarkerStrategy string
+
+const (
+	// MarkerBlobTag tags every deployed file, it requires the blob tag permissions and no hierarchical namespace
+	MarkerBlobTag MarkerStrategy = "BlobTag"
+	// MarkerMetadata sets the version in the metadata of every deployed file
+	MarkerMetadata MarkerStrategy = "Metadata"
+	// MarkerFile writes the version in a dedicated file once the package is deployed
+	MarkerFile MarkerStrategy = "File"
+	// MarkerMetaTag injects a <meta> tag carrying the version in the HTML file to check
+	MarkerMetaTag MarkerStrategy = "MetaTag"
+)
+
+// VersionMarker configures how the deployed version is recorded and read back.
+// The blobTagKey is the tag key, the metadata key, the JSON field or the meta tag name depending on the strategy.
+type VersionMarker struct {
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default:=BlobTag
+	Strategy MarkerStrategy `json:"strategy,omitempty"`
+	// FileName is the file written by the File strategy, a JSON document for .json files, the bare version otherwise
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default:=version.json
+	FileName string `json:"fileName,omitempty"`
+}
+
+// Cloud is the Azure cloud the storage accounts are located in
+// +kubebuilder:validation:Enum=Public;China;USGov
+type Cloud string
+
+const (
+	CloudPublic Cloud = "Public"
+	CloudChina  Cloud = "China"
+	CloudUSGov  Cloud = "USGov"
+)
+
+// StorageEndpoint locates the blob service of the storage accounts
+type StorageEndpoint struct {
+	// Cloud of the storage accounts and of the SPN
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default:=Public
+	Cloud Cloud `json:"cloud,omitempty"`
+	// BlobEndpoint replaces the blob service of the cloud, e.g. http://azurite:10000.
+	// Plain http requires the account keys, the SPN tokens are only sent over https.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Pattern=`^https?://`
+	BlobEndpoint string `json:"blobEndpoint,omitempty"`
+	// PathStyle puts the storage account name in the path of the blob endpoint instead of its host, as Azurite expects
+	// +kubebuilder:validation:Optional
+	PathStyle bool `json:"pathStyle,omitempty"`
+}
+
+// Target deploys a directory of the package to its own storage location, with its own version marker
+type Target struct {
+	// Name identifies the target in the status
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`
+	Name string `json:"name"`
+	// Path is the directory of the package deployed by the target, e.g. "checkout", the whole package when empty
+	// +kubebuilder:validation:Optional
+	Path string `json:"path,omitempty"`
+	// StorageName defaults to the Webapp one, the account key of the Webapp is only used on its own storage account
+	// +kubebuilder:validation:Optional
+	StorageName string `json:"storageName,omitempty"`
+	// ContainerName defaults to the Webapp one
+	// +kubebuilder:validation:Optional
+	ContainerName string `json:"containerName,omitempty"`
+	// Prefix is the folder of the container the files are deployed to, the root of the container when empty
+	// +kubebuilder:validation:Optional
+	Prefix string `json:"prefix,omitempty"`
+	// FileNameToCheck is the file of the target carrying its version, defaults to the Webapp one
+	// +kubebuilder:validation:Optional
+	FileNameToCheck string `json:"fileNameToCheck,omitempty"`
+	// VersionMarker defaults to the Webapp one
+	// +kubebuilder:validation:Optional
+	VersionMarker *VersionMarker `json:"versionMarker,omitempty"`
+}
+
 // WebappSpec defines the desired state of Webapp
 type WebappSpec struct {
-	// +kubebuilder:validation:Required
+	// The SPN is required unless both storage accounts are accessed with their account key
+	// +kubebuilder:validation:Optional
 	AzureTenantId string `json:"azureTenantId"`
-	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Optional
 	AzureSpnId string `json:"azureSpnId"`
-	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Optional
 	AzureSpnSecret string `json:"azureSpnSecret"`
+	// AzureStorageAccountKey authenticates on the storage account instead of the SPN
+	// +kubebuilder:validation:Optional
+	AzureStorageAccountKey string `json:"azureStorageAccountKey,omitempty"`
+	// AzurePackageStorageAccountKey authenticates on the package storage account instead of the SPN
+	// +kubebuilder:validation:Optional
+	AzurePackageStorageAccountKey string `json:"azurePackageStorageAccountKey,omitempty"`
 	// +kubebuilder:validation:Required
 	StorageName string `json:"storageName"`
 	// +kubebuilder:validation:Optional
@@ -42,13 +285,59 @@ type WebappSpec struct {
 	// +kubebuilder:validation:Optional
 	// +kubebuilder:default:=version
 	BlobTagKey string `json:"blobTagKey"`
-	// +kubebuilder:validation:Required
-	VersionToDeploy string `json:"versionToDeploy"`
+	// VersionToDeploy is required unless the version policy tracks the latest package
+	// +kubebuilder:validation:Optional
+	VersionToDeploy string `json:"versionToDeploy,omitempty"`
 	// +kubebuilder:validation:Required
 	PackageStorageName string `json:"packageStorageName"`
 	// +kubebuilder:validation:Optional
 	// +kubebuilder:default:=packages
 	PackageContainerName string `json:"packageContainerName"`
+	// DeploymentTimeout bounds the duration of a deployment, defaults to the operator --deployment-timeout flag
+	// +kubebuilder:validation:Optional
+	DeploymentTimeout *metav1.Duration `json:"deploymentTimeout,omitempty"`
+	// RollbackTo redeploys a version from the status history, either a revision number or "previous".
+	// It is cleared by the operator once the rollback is done.
+	// +kubebuilder:validation:Optional
+	RollbackTo string `json:"rollbackTo,omitempty"`
+	// DryRun computes the deployment plan into the status without uploading anything
+	// +kubebuilder:validation:Optional
+	DryRun bool `json:"dryRun,omitempty"`
+	// ApprovalPolicy set to Manual waits for the ApprovedVersionAnnotation before deploying a new version.
+	// Rollbacks are not subject to approval.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default:=Automatic
+	ApprovalPolicy ApprovalPolicy `json:"approvalPolicy,omitempty"`
+	// Suspend stops the deployments and the drift remediation of the Webapp. Once unsuspended, the latest spec is deployed.
+	// +kubebuilder:validation:Optional
+	Suspend bool `json:"suspend,omitempty"`
+	// DeploymentWindows restricts the deployment of new versions to these time ranges, any time when empty.
+	// Rollbacks are not subject to the windows.
+	// +kubebuilder:validation:Optional
+	DeploymentWindows []DeploymentWindow `json:"deploymentWindows,omitempty"`
+	// VersionPolicy set to the Latest mode deploys the latest package instead of VersionToDeploy.
+	// A rollback pins the version back.
+	// +kubebuilder:validation:Optional
+	VersionPolicy *VersionPolicy `json:"versionPolicy,omitempty"`
+	// BlueGreen enables the blue/green deployments, a rollback to the version of the idle slot is an instant switch back
+	// +kubebuilder:validation:Optional
+	BlueGreen *BlueGreen `json:"blueGreen,omitempty"`
+	// SiteUrl is the public address of the site, used to build the preview URLs
+	// +kubebuilder:validation:Optional
+	SiteUrl string `json:"siteUrl,omitempty"`
+	// Verification checks the site after each deployment, the deployment fails when the checks do not pass
+	// +kubebuilder:validation:Optional
+	Verification *Verification `json:"verification,omitempty"`
+	// VersionMarker selects how the deployed version is recorded, blob tags by default
+	// +kubebuilder:validation:Optional
+	VersionMarker *VersionMarker `json:"versionMarker,omitempty"`
+	// Endpoint locates the storage accounts, defaults to the operator --cloud and --blob-endpoint flags
+	// +kubebuilder:validation:Optional
+	Endpoint *StorageEndpoint `json:"endpoint,omitempty"`
+	// Targets split the package between several storage locations, deployed in the order of the list.
+	// When set, the package is deployed to the targets instead of the Webapp container. Not supported with BlueGreen.
+	// +kubebuilder:validation:Optional
+	Targets []Target `json:"targets,omitempty"`
 }
 
 // WebappStatus defines the observed state of Webapp
@@ -60,12 +349,72 @@ type WebappStatus struct {
 	//Error           string             `json:"error"`
 	//LastUpdate      string             `json:"last-update"`
 	Conditions []metav1.Condition `json:"conditions"`
+	// History lists the last deployments, the most recent one last
+	History []DeploymentRecord `json:"history,omitempty"`
+	// ResolvedVersion is the latest package version found by the version policy
+	ResolvedVersion string `json:"resolvedVersion,omitempty"`
+	// ActiveSlot is the live blue/green slot
+	ActiveSlot string `json:"activeSlot,omitempty"`
+	// Slots lists the version deployed in each blue/green slot
+	Slots []SlotStatus `json:"slots,omitempty"`
+	// Plan is the result of the last dry run
+	Plan *DeploymentPlan `json:"plan,omitempty"`
+	// Targets reports the version deployed on each target, in the order of the spec
+	Targets []TargetStatus `json:"targets,omitempty"`
+}
+
+// DeploymentRecord describes a version successfully deployed by the operator
+type DeploymentRecord struct {
+	Revision   int64       `json:"revision"`
+	Version    string      `json:"version"`
+	DeployedAt metav1.Time `json:"deployedAt"`
+}
+
+// TargetStatus is the deployment state of a target
+type TargetStatus struct {
+	Name            string `json:"name"`
+	DeployedVersion string `json:"deployedVersion,omitempty"`
+	// Status is SUCCESS, ERROR or PENDING when a previous target failed
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+	// DeployedAt is the last time the package was uploaded to the target
+	DeployedAt *metav1.Time `json:"deployedAt,omitempty"`
+}
+
+// SlotStatus is the version deployed in a blue/green slot
+type SlotStatus struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// DeploymentPlan describes what deploying a version would change in the target container
+type DeploymentPlan struct {
+	Version         string `json:"version"`
+	DeployedVersion string `json:"deployedVersion,omitempty"`
+	Added           int    `json:"added"`
+	Changed         int    `json:"changed"`
+	Unchanged       int    `json:"unchanged"`
+	// Stale is the number of files missing from the package, the deployment leaves them in place
+	Stale int `json:"stale"`
+	// Files lists the changes, at most MaxPlannedFiles of them
+	Files []PlannedFile `json:"files,omitempty"`
+	// Truncated tells whether some changes are missing from Files
+	Truncated bool        `json:"truncated,omitempty"`
+	PlannedAt metav1.Time `json:"plannedAt"`
+}
+
+// PlannedFile is a file the deployment would add or change, or a stale file it would leave in place
+type PlannedFile struct {
+	Path string `json:"path"`
+	// +kubebuilder:validation:Enum=Add;Change;Stale
+	Action string `json:"action"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
 //+kubebuilder:printcolumn:name="Status",type="string",JSONPath=".status.status",description="The status of the last sync"
 //+kubebuilder:printcolumn:name="Current Deployed Version",type="string",JSONPath=".status.deployed-version",description="The version currently deployed"
+//+kubebuilder:printcolumn:name="Active Slot",type="string",JSONPath=".status.activeSlot",description="The live blue/green slot",priority=1
 //+kubebuilder:printcolumn:name="Desired Version",type="string",JSONPath=".spec.webappversion",description="The desired version"
 // Webapp is the Schema for the webapps API
 type Webapp struct {
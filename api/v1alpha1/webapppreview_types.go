@@ -0,0 +1,85 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// PreviewFinalizer removes the files of a preview before it is deleted
+const PreviewFinalizer = "webapp.simpletest.com/preview-cleanup"
+
+// PreviewsFinalizer deletes the previews of a Webapp, and waits for their files to be removed, before the Webapp is deleted
+const PreviewsFinalizer = "webapp.simpletest.com/previews-cleanup"
+
+// WebappPreviewSpec defines the desired state of WebappPreview
+type WebappPreviewSpec struct {
+	// WebappName is the Webapp of the namespace the preview is made of: credentials, storages and site URL.
+	// The preview is deleted with it.
+	// +kubebuilder:validation:Required
+	WebappName string `json:"webappName"`
+	// Version is the package version to preview
+	// +kubebuilder:validation:Required
+	Version string `json:"version"`
+	// Prefix is the folder the preview is deployed to, defaults to previews/<name>/.
+	// A preview never owns a whole container, nor a location deployed by a Webapp.
+	// +kubebuilder:validation:Optional
+	Prefix string `json:"prefix,omitempty"`
+	// ContainerName deploys the preview under its prefix in another container of the Webapp storage account
+	// +kubebuilder:validation:Optional
+	ContainerName string `json:"containerName,omitempty"`
+	// Ttl deletes the preview, and its files, once expired
+	// +kubebuilder:validation:Optional
+	Ttl *metav1.Duration `json:"ttl,omitempty"`
+}
+
+// WebappPreviewStatus defines the observed state of WebappPreview
+type WebappPreviewStatus struct {
+	Status          string `json:"status,omitempty"`
+	DeployedVersion string `json:"deployedVersion,omitempty"`
+	// Url is the address of the deployed preview
+	Url string `json:"url,omitempty"`
+	// ExpiresAt is the time the preview is deleted at, if it has a TTL
+	ExpiresAt  *metav1.Time       `json:"expiresAt,omitempty"`
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Version",type="string",JSONPath=".status.deployedVersion",description="The version deployed in the preview"
+//+kubebuilder:printcolumn:name="Url",type="string",JSONPath=".status.url",description="The address of the preview"
+//+kubebuilder:printcolumn:name="Expires",type="date",JSONPath=".status.expiresAt",description="The time the preview is deleted at"
+// WebappPreview is the Schema for the webapppreviews API
+type WebappPreview struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   WebappPreviewSpec   `json:"spec,omitempty"`
+	Status WebappPreviewStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+// WebappPreviewList contains a list of WebappPreview
+type WebappPreviewList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []WebappPreview `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&WebappPreview{}, &WebappPreviewList{})
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	webappv1alpha1 "github.com/morganleroi/deploy-website-k8s-operator/api/v1alpha1"
+)
+
+// Conditions of the Webapp status summarized by the list command
+const (
+	conditionDegraded         = "Degraded"
+	conditionPendingApproval  = "PendingApproval"
+	conditionSuspended        = "Suspended"
+	conditionWaitingForWindow = "WaitingForWindow"
+)
+
+// plugin holds the connection shared by the commands
+type plugin struct {
+	client       client.Client
+	namespace    string
+	out          io.Writer
+	pollInterval time.Duration
+}
+
+func (p *plugin) get(ctx context.Context, name string) (*webappv1alpha1.Webapp, error) {
+	webapp := &webappv1alpha1.Webapp{}
+	if err := p.client.Get(ctx, types.NamespacedName{Namespace: p.namespace, Name: name}, webapp); err != nil {
+		return nil, err
+	}
+	return webapp, nil
+}
+
+// patch applies the changes made by mutate to the Webapp with a merge patch
+func (p *plugin) patch(ctx context.Context, name string, mutate func(webapp *webappv1alpha1.Webapp) error) (*webappv1alpha1.Webapp, error) {
+	webapp, err := p.get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	original := webapp.DeepCopy()
+	if err := mutate(webapp); err != nil {
+		return nil, err
+	}
+	if err := p.client.Patch(ctx, webapp, client.MergeFrom(original)); err != nil {
+		return nil, err
+	}
+	return webapp, nil
+}
+
+func setAnnotation(webapp *webappv1alpha1.Webapp, annotation string, value string) {
+	if webapp.Annotations == nil {
+		webapp.Annotations = map[string]string{}
+	}
+	webapp.Annotations[annotation] = value
+}
+
+// nameArgument returns the single Webapp name of the arguments
+func nameArgument(args []string) (string, error) {
+	if len(args) != 1 {
+		return "", usageError("expecting a single Webapp name")
+	}
+	return args[0], nil
+}
+
+// desiredVersion returns the version the operator deploys, the resolved one when tracking the latest version
+func desiredVersion(webapp *webappv1alpha1.Webapp) string {
+	if webapp.Spec.VersionPolicy != nil && webapp.Spec.VersionPolicy.Mode == webappv1alpha1.VersionModeLatest && webapp.Status.ResolvedVersion != "" {
+		return webapp.Status.ResolvedVersion
+	}
+	return webapp.Spec.VersionToDeploy
+}
+
+// state summarizes why the desired version is deployed or not
+func state(webapp *webappv1alpha1.Webapp) string {
+	conditions := webapp.Status.Conditions
+	switch {
+	case webapp.Spec.Suspend, meta.IsStatusConditionTrue(conditions, conditionSuspended):
+		return conditionSuspended
+	case meta.IsStatusConditionTrue(conditions, conditionPendingApproval):
+		return conditionPendingApproval
+	case meta.IsStatusConditionTrue(conditions, conditionWaitingForWindow):
+		return conditionWaitingForWindow
+	case meta.IsStatusConditionTrue(conditions, conditionDegraded):
+		return meta.FindStatusCondition(conditions, conditionDegraded).Reason
+	}
+	return orNone(webapp.Status.Status)
+}
+
+func bindList(p *plugin, flags *flag.FlagSet) func(ctx context.Context, args []string) error {
+	var allNamespaces bool
+	flags.BoolVar(&allNamespaces, "all-namespaces", false, "List the Webapps of every namespace")
+	flags.BoolVar(&allNamespaces, "A", false, "Shorthand for --all-namespaces")
+
+	return func(ctx context.Context, args []string) error {
+		if len(args) > 0 {
+			return usageError("list does not take arguments")
+		}
+		webapps := &webappv1alpha1.WebappList{}
+		var options []client.ListOption
+		if !allNamespaces {
+			options = append(options, client.InNamespace(p.namespace))
+		}
+		if err := p.client.List(ctx, webapps, options...); err != nil {
+			return err
+		}
+
+		writer := tabwriter.NewWriter(p.out, 0, 4, 3, ' ', 0)
+		if allNamespaces {
+			fmt.Fprint(writer, "NAMESPACE\t")
+		}
+		fmt.Fprintln(writer, "NAME\tDESIRED\tDEPLOYED\tSYNCED\tSTATE")
+		for i := range webapps.Items {
+			webapp := &webapps.Items[i]
+			if allNamespaces {
+				fmt.Fprintf(writer, "%s\t", webapp.Namespace)
+			}
+			desired := desiredVersion(webapp)
+			synced := "No"
+			if desired != "" && desired == webapp.Status.DeployedVersion {
+				synced = "Yes"
+			}
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\n", webapp.Name, orNone(desired), orNone(webapp.Status.DeployedVersion), synced, state(webapp))
+		}
+		return writer.Flush()
+	}
+}
+
+func bindDeploy(p *plugin, flags *flag.FlagSet) func(ctx context.Context, args []string) error {
+	version := flags.String("version", "", "The version to deploy")
+
+	return func(ctx context.Context, args []string) error {
+		name, err := nameArgument(args)
+		if err != nil {
+			return err
+		}
+		if *version == "" {
+			return usageError("--version is required")
+		}
+
+		_, err = p.patch(ctx, name, func(webapp *webappv1alpha1.Webapp) error {
+			webapp.Spec.VersionToDeploy = *version
+			// A pinned version would be replaced by the next resolution of the latest version
+			if webapp.Spec.VersionPolicy != nil {
+				webapp.Spec.VersionPolicy.Mode = webappv1alpha1.VersionModePinned
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(p.out, "webapp/%s deploying %s\n", name, *version)
+		return nil
+	}
+}
+
+func bindRollback(p *plugin, flags *flag.FlagSet) func(ctx context.Context, args []string) error {
+	to := flags.String("to", webappv1alpha1.RollbackPrevious, "The revision to roll back to, see the history command")
+
+	return func(ctx context.Context, args []string) error {
+		name, err := nameArgument(args)
+		if err != nil {
+			return err
+		}
+
+		var version string
+		_, err = p.patch(ctx, name, func(webapp *webappv1alpha1.Webapp) error {
+			// The operator resolves the revision too, checking it here reports the mistakes right away
+			if version, err = historyVersion(webapp, *to); err != nil {
+				return err
+			}
+			setAnnotation(webapp, webappv1alpha1.RollbackAnnotation, *to)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(p.out, "webapp/%s rolling back to %s\n", name, version)
+		return nil
+	}
+}
+
+// historyVersion returns the version of a revision of the history, or of the previous revision
+func historyVersion(webapp *webappv1alpha1.Webapp, revision string) (string, error) {
+	history := webapp.Status.History
+	if revision == webappv1alpha1.RollbackPrevious {
+		if len(history) < 2 {
+			return "", fmt.Errorf("webapp/%s has no previous revision", webapp.Name)
+		}
+		return history[len(history)-2].Version, nil
+	}
+
+	number, err := strconv.ParseInt(revision, 10, 64)
+	if err != nil {
+		return "", usageError(fmt.Sprintf("invalid revision %q: expecting a revision number or %q", revision, webappv1alpha1.RollbackPrevious))
+	}
+	for _, record := range history {
+		if record.Revision == number {
+			return record.Version, nil
+		}
+	}
+	return "", fmt.Errorf("webapp/%s has no revision %d", webapp.Name, number)
+}
+
+func bindHistory(p *plugin, _ *flag.FlagSet) func(ctx context.Context, args []string) error {
+	return func(ctx context.Context, args []string) error {
+		name, err := nameArgument(args)
+		if err != nil {
+			return err
+		}
+		webapp, err := p.get(ctx, name)
+		if err != nil {
+			return err
+		}
+
+		writer := tabwriter.NewWriter(p.out, 0, 4, 3, ' ', 0)
+		fmt.Fprintln(writer, "REVISION\tVERSION\tDEPLOYED AT")
+		for _, record := range webapp.Status.History {
+			fmt.Fprintf(writer, "%d\t%s\t%s\n", record.Revision, record.Version, record.DeployedAt.UTC().Format(time.RFC3339))
+		}
+		return writer.Flush()
+	}
+}
+
+func bindApprove(p *plugin, flags *flag.FlagSet) func(ctx context.Context, args []string) error {
+	version := flags.String("version", "", "The version to approve, the desired version by default")
+
+	return func(ctx context.Context, args []string) error {
+		name, err := nameArgument(args)
+		if err != nil {
+			return err
+		}
+
+		approved := *version
+		_, err = p.patch(ctx, name, func(webapp *webappv1alpha1.Webapp) error {
+			if webapp.Spec.ApprovalPolicy != webappv1alpha1.ApprovalPolicyManual {
+				return fmt.Errorf("webapp/%s does not require approvals", name)
+			}
+			if approved == "" {
+				approved = desiredVersion(webapp)
+			}
+			if approved == webapp.Status.DeployedVersion {
+				return fmt.Errorf("version %s is already deployed", approved)
+			}
+			setAnnotation(webapp, webappv1alpha1.ApprovedVersionAnnotation, approved)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(p.out, "webapp/%s version %s approved\n", name, approved)
+		return nil
+	}
+}
+
+func bindPause(p *plugin, _ *flag.FlagSet) func(ctx context.Context, args []string) error {
+	return func(ctx context.Context, args []string) error {
+		name, err := nameArgument(args)
+		if err != nil {
+			return err
+		}
+		_, err = p.patch(ctx, name, func(webapp *webappv1alpha1.Webapp) error {
+			webapp.Spec.Suspend = true
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(p.out, "webapp/%s paused\n", name)
+		return nil
+	}
+}
+
+func bindResume(p *plugin, _ *flag.FlagSet) func(ctx context.Context, args []string) error {
+	return func(ctx context.Context, args []string) error {
+		name, err := nameArgument(args)
+		if err != nil {
+			return err
+		}
+		_, err = p.patch(ctx, name, func(webapp *webappv1alpha1.Webapp) error {
+			webapp.Spec.Suspend = false
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(p.out, "webapp/%s resumed\n", name)
+		return nil
+	}
+}
+
+// bindDiff prints the plan of the desired version. Unless the status already holds it, the plan is requested with the
+// dry run annotation, which is removed once the operator has planned the deployment.
+func bindDiff(p *plugin, flags *flag.FlagSet) func(ctx context.Context, args []string) error {
+	refresh := flags.Bool("refresh", false, "Plan the deployment again even if the status holds the plan of the desired version")
+	timeout := flags.Duration("timeout", time.Minute, "How long to wait for the operator to plan the deployment")
+
+	return func(ctx context.Context, args []string) error {
+		name, err := nameArgument(args)
+		if err != nil {
+			return err
+		}
+		webapp, err := p.get(ctx, name)
+		if err != nil {
+			return err
+		}
+
+		version := desiredVersion(webapp)
+		if plan := webapp.Status.Plan; !*refresh && plan != nil && plan.Version == version {
+			printPlan(p.out, plan)
+			return nil
+		}
+
+		requestedAt := time.Now().Truncate(time.Second)
+		requested := webapp.Annotations[webappv1alpha1.DryRunAnnotation] != "true"
+		if requested {
+			if _, err := p.patch(ctx, name, func(webapp *webappv1alpha1.Webapp) error {
+				setAnnotation(webapp, webappv1alpha1.DryRunAnnotation, "true")
+				return nil
+			}); err != nil {
+				return err
+			}
+			defer func() {
+				// The context may be cancelled, the annotation must be removed anyway
+				cleanupCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+				defer cancel()
+				if _, err := p.patch(cleanupCtx, name, func(webapp *webappv1alpha1.Webapp) error {
+					delete(webapp.Annotations, webappv1alpha1.DryRunAnnotation)
+					return nil
+				}); err != nil {
+					fmt.Fprintf(p.out, "unable to remove the %s annotation: %v\n", webappv1alpha1.DryRunAnnotation, err)
+				}
+			}()
+		}
+
+		plan, err := p.waitForPlan(ctx, name, version, requestedAt, *timeout)
+		if err != nil {
+			return err
+		}
+		printPlan(p.out, plan)
+		return nil
+	}
+}
+
+// waitForPlan polls the Webapp until the operator planned the version
+func (p *plugin) waitForPlan(ctx context.Context, name string, version string, requestedAt time.Time, timeout time.Duration) (*webappv1alpha1.DeploymentPlan, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	ticker := time.NewTicker(p.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		webapp, err := p.get(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		if plan := webapp.Status.Plan; plan != nil && plan.Version == version && !plan.PlannedAt.Time.Before(requestedAt) {
+			return plan, nil
+		}
+		if condition := meta.FindStatusCondition(webapp.Status.Conditions, conditionDegraded); condition != nil &&
+			condition.Status == v1.ConditionTrue && !condition.LastTransitionTime.Time.Before(requestedAt) {
+			return nil, fmt.Errorf("the operator failed to plan version %s: %s", version, condition.Message)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil, fmt.Errorf("version %s was not planned in %s, is the operator running?", version, timeout)
+		}
+	}
+}
+
+func printPlan(out io.Writer, plan *webappv1alpha1.DeploymentPlan) {
+	fmt.Fprintf(out, "Version %s, deployed %s, planned at %s\n", plan.Version, orNone(plan.DeployedVersion), plan.PlannedAt.UTC().Format(time.RFC3339))
+	signs := map[string]string{"Add": "+", "Change": "~", "Stale": "?"}
+	for _, file := range plan.Files {
+		fmt.Fprintf(out, "%s %s\n", signs[file.Action], file.Path)
+	}
+	if plan.Truncated {
+		fmt.Fprintf(out, "... only the first %d files are listed\n", len(plan.Files))
+	}
+	fmt.Fprintf(out, "%d added, %d changed, %d unchanged, %d stale files left in place\n", plan.Added, plan.Changed, plan.Unchanged, plan.Stale)
+}
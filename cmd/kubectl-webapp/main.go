@@ -0,0 +1,181 @@
+// Command kubectl-webapp is a kubectl plugin operating the Webapps of the operator: kubectl webapp <command>.
+// It only edits the Webapps, the operator carries out the deployments.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
+	_ "k8s.io/client-go/plugin/pkg/client/auth"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	webappv1alpha1 "github.com/morganleroi/deploy-website-k8s-operator/api/v1alpha1"
+)
+
+// command is a subcommand of the plugin, bind defines its flags and returns the function running it
+type command struct {
+	usage   string
+	summary string
+	bind    func(p *plugin, flags *flag.FlagSet) func(ctx context.Context, args []string) error
+}
+
+var commands = map[string]command{
+	"list":     {"list [-A]", "lists the Webapps with their desired and deployed versions", bindList},
+	"deploy":   {"deploy <name> --version <version>", "pins the version to deploy", bindDeploy},
+	"rollback": {"rollback <name> [--to <revision>]", "rolls back to a revision of the history, the previous one by default", bindRollback},
+	"history":  {"history <name>", "lists the deployed revisions", bindHistory},
+	"approve":  {"approve <name> [--version <version>]", "approves the pending version under the Manual approval policy", bindApprove},
+	"pause":    {"pause <name>", "suspends the deployments of the Webapp", bindPause},
+	"resume":   {"resume <name>", "resumes the deployments from the latest spec", bindResume},
+	"diff":     {"diff <name>", "prints the files deploying the desired version would add and change, and the stale files it leaves", bindDiff},
+}
+
+// newClientFunc connects to the cluster of the kubeconfig context, it returns the client and the context namespace
+type newClientFunc func(kubeconfig string, kubeContext string) (client.Client, string, error)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr, newClient))
+}
+
+func usage(output io.Writer) {
+	fmt.Fprintln(output, "Usage: kubectl webapp <command> [flags]\n\nCommands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(output, "  %-40s %s\n", commands[name].usage, commands[name].summary)
+	}
+	fmt.Fprintln(output, "\nFlags of every command: -n/--namespace, --kubeconfig, --context")
+}
+
+// run executes the command line and returns the exit code: 1 when the command fails, 2 when it is invalid
+func run(args []string, stdout io.Writer, stderr io.Writer, connect newClientFunc) int {
+	if len(args) == 0 {
+		usage(stderr)
+		return 2
+	}
+	command, ok := commands[args[0]]
+	if !ok {
+		usage(stderr)
+		return 2
+	}
+
+	p := &plugin{out: stdout, pollInterval: 2 * time.Second}
+	flags := flag.NewFlagSet("kubectl webapp "+args[0], flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: kubectl webapp %s\n", command.usage)
+		flags.PrintDefaults()
+	}
+	kubeconfig := flags.String("kubeconfig", "", "Path to the kubeconfig file")
+	kubeContext := flags.String("context", "", "The kubeconfig context to use")
+	flags.StringVar(&p.namespace, "namespace", "", "The namespace of the Webapps, the one of the context by default")
+	flags.StringVar(&p.namespace, "n", "", "Shorthand for --namespace")
+	runCommand := command.bind(p, flags)
+
+	positional, err := parseInterspersed(flags, args[1:])
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+
+	c, namespace, err := connect(*kubeconfig, *kubeContext)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	p.client = c
+	if p.namespace == "" {
+		p.namespace = namespace
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := runCommand(ctx, positional); err != nil {
+		var usageError usageError
+		if errors.As(err, &usageError) {
+			fmt.Fprintln(stderr, err)
+			flags.Usage()
+			return 2
+		}
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// parseInterspersed parses the flags wherever they are, kubectl style, and returns the other arguments
+func parseInterspersed(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		if args[0] == "--" {
+			return append(positional, args[1:]...), nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// usageError reports invalid arguments
+type usageError string
+
+func (e usageError) Error() string {
+	return string(e)
+}
+
+func newClient(kubeconfig string, kubeContext string) (client.Client, string, error) {
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules.ExplicitPath = kubeconfig
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, &clientcmd.ConfigOverrides{CurrentContext: kubeContext})
+
+	namespace, _, err := clientConfig.Namespace()
+	if err != nil {
+		return nil, "", err
+	}
+	config, err := clientConfig.ClientConfig()
+	if err != nil {
+		return nil, "", err
+	}
+
+	scheme := runtime.NewScheme()
+	if err := webappv1alpha1.AddToScheme(scheme); err != nil {
+		return nil, "", err
+	}
+	c, err := client.New(config, client.Options{Scheme: scheme})
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to connect to the cluster: %w", err)
+	}
+	return c, namespace, nil
+}
+
+// orNone returns the value, "<none>" when empty like kubectl does
+func orNone(value string) string {
+	if strings.TrimSpace(value) == "" {
+		return "<none>"
+	}
+	return value
+}
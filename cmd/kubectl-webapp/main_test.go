@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	webappv1alpha1 "github.com/morganleroi/deploy-website-k8s-operator/api/v1alpha1"
+)
+
+func TestCommands(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := webappv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	webapp := &webappv1alpha1.Webapp{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "sites", Name: "site"},
+		Spec: webappv1alpha1.WebappSpec{
+			VersionToDeploy: "v1.1.0",
+			ApprovalPolicy:  webappv1alpha1.ApprovalPolicyManual,
+		},
+		Status: webappv1alpha1.WebappStatus{
+			DeployedVersion: "v1.0.0",
+			History: []webappv1alpha1.DeploymentRecord{
+				{Revision: 1, Version: "v0.9.0"},
+				{Revision: 2, Version: "v1.0.0"},
+			},
+		},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(webapp).Build()
+	connect := func(string, string) (client.Client, string, error) {
+		return c, "sites", nil
+	}
+	runCommand := func(args ...string) (int, string) {
+		stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+		code := run(args, stdout, stderr, connect)
+		if code != 0 {
+			t.Logf("%v: %s", args, stderr)
+		}
+		return code, stdout.String()
+	}
+	get := func() *webappv1alpha1.Webapp {
+		webapp := &webappv1alpha1.Webapp{}
+		if err := c.Get(context.Background(), types.NamespacedName{Namespace: "sites", Name: "site"}, webapp); err != nil {
+			t.Fatal(err)
+		}
+		return webapp
+	}
+
+	if code, out := runCommand("list"); code != 0 || !strings.Contains(out, "v1.1.0") || !strings.Contains(out, "No") {
+		t.Fatalf("unexpected list %d %q", code, out)
+	}
+	if code, out := runCommand("history", "site"); code != 0 || !strings.Contains(out, "2   ") || !strings.Contains(out, "v0.9.0") {
+		t.Fatalf("unexpected history %d %q", code, out)
+	}
+	if code, _ := runCommand("approve", "site"); code != 0 || get().Annotations[webappv1alpha1.ApprovedVersionAnnotation] != "v1.1.0" {
+		t.Fatalf("the desired version should be approved")
+	}
+	if code, _ := runCommand("rollback", "site", "--to", "5"); code != 1 {
+		t.Fatalf("a rollback to an unknown revision should fail")
+	}
+	if code, _ := runCommand("rollback", "-n", "sites", "site"); code != 0 || get().Annotations[webappv1alpha1.RollbackAnnotation] != "previous" {
+		t.Fatalf("the rollback annotation should be set")
+	}
+	if code, _ := runCommand("deploy", "site", "--version", "v1.2.0"); code != 0 || get().Spec.VersionToDeploy != "v1.2.0" {
+		t.Fatalf("the version to deploy should be pinned")
+	}
+	if code, _ := runCommand("deploy", "site"); code != 2 {
+		t.Fatalf("deploy without --version should be rejected")
+	}
+	if code, out := runCommand("pause", "site"); code != 0 || !get().Spec.Suspend {
+		t.Fatalf("unexpected pause %d %q", code, out)
+	}
+	if code, out := runCommand("list"); code != 0 || !strings.Contains(out, "Suspended") {
+		t.Fatalf("the Webapp should be listed as suspended, got %q", out)
+	}
+	if code, _ := runCommand("resume", "site"); code != 0 || get().Spec.Suspend {
+		t.Fatalf("the Webapp should be resumed")
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"sigs.k8s.io/yaml"
+
+	webappv1alpha1 "github.com/morganleroi/deploy-website-k8s-operator/api/v1alpha1"
+)
+
+// envPrefix prefixes the environment variables setting the flags, e.g. WEBAPP_DEPLOY_SPN_SECRET for -spnSecret
+const envPrefix = "WEBAPP_DEPLOY_"
+
+// envName returns the environment variable of a flag: -fileNameToCheck is read from WEBAPP_DEPLOY_FILE_NAME_TO_CHECK
+func envName(flagName string) string {
+	var name strings.Builder
+	name.WriteString(envPrefix)
+	for i, r := range flagName {
+		switch {
+		case r == '-' || r == '.':
+			name.WriteRune('_')
+		case unicode.IsUpper(r) && i > 0:
+			name.WriteRune('_')
+			name.WriteRune(r)
+		default:
+			name.WriteRune(unicode.ToUpper(r))
+		}
+	}
+	return name.String()
+}
+
+// loadConfig completes the parsed flags. A flag given on the command line wins over its environment variable,
+// which wins over the Webapp file named by the fileFlag flag, which wins over the flag default.
+func loadConfig(flags *flag.FlagSet, fileFlag string, lookupEnv func(string) (string, bool)) error {
+	set := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	var err error
+	flags.VisitAll(func(f *flag.Flag) {
+		value, ok := lookupEnv(envName(f.Name))
+		if set[f.Name] || !ok || err != nil {
+			return
+		}
+		if err = flags.Set(f.Name, value); err != nil {
+			err = fmt.Errorf("invalid %s environment variable: %w", envName(f.Name), err)
+		}
+		set[f.Name] = true
+	})
+	webappFile := flags.Lookup(fileFlag).Value.String()
+	if err != nil || webappFile == "" {
+		return err
+	}
+
+	webapp, err := readWebapp(webappFile)
+	if err != nil {
+		return err
+	}
+	for name, value := range webappFlags(webapp) {
+		if set[name] || flags.Lookup(name) == nil {
+			continue
+		}
+		if err := flags.Set(name, value); err != nil {
+			return fmt.Errorf("invalid %s in %s: %w", name, webappFile, err)
+		}
+	}
+	return nil
+}
+
+func readWebapp(webappFile string) (*webappv1alpha1.Webapp, error) {
+	content, err := os.ReadFile(webappFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the Webapp file: %w", err)
+	}
+	webapp := &webappv1alpha1.Webapp{}
+	if err := yaml.Unmarshal(content, webapp); err != nil {
+		return nil, fmt.Errorf("invalid Webapp file %s: %w", webappFile, err)
+	}
+	if webapp.Kind != "" && webapp.Kind != "Webapp" {
+		return nil, fmt.Errorf("invalid Webapp file %s: %s kind instead of Webapp", webappFile, webapp.Kind)
+	}
+	if fields := unsupportedFields(webapp); len(fields) > 0 {
+		return nil, fmt.Errorf("invalid Webapp file %s: %s not supported outside of the operator", webappFile, strings.Join(fields, ", "))
+	}
+	return webapp, nil
+}
+
+// unsupportedFields lists the spec fields and annotations the CLI does not honour, which would otherwise be ignored
+func unsupportedFields(webapp *webappv1alpha1.Webapp) []string {
+	spec := webapp.Spec
+	var fields []string
+	if spec.BlueGreen != nil {
+		fields = append(fields, "spec.blueGreen")
+	}
+	if len(spec.Targets) > 0 {
+		fields = append(fields, "spec.targets")
+	}
+	if policy := spec.VersionPolicy; policy != nil {
+		if policy.Mode != "" && policy.Mode != webappv1alpha1.VersionModePinned {
+			fields = append(fields, "spec.versionPolicy.mode")
+		}
+		if policy.Constraint != "" {
+			fields = append(fields, "spec.versionPolicy.constraint")
+		}
+		if policy.PollInterval != nil {
+			fields = append(fields, "spec.versionPolicy.pollInterval")
+		}
+	}
+	if spec.Suspend {
+		fields = append(fields, "spec.suspend")
+	}
+	if spec.DryRun {
+		fields = append(fields, "spec.dryRun")
+	}
+	if spec.RollbackTo != "" {
+		fields = append(fields, "spec.rollbackTo")
+	}
+	if spec.Verification != nil {
+		fields = append(fields, "spec.verification")
+	}
+	if len(spec.DeploymentWindows) > 0 {
+		fields = append(fields, "spec.deploymentWindows")
+	}
+	if spec.ApprovalPolicy != "" && spec.ApprovalPolicy != webappv1alpha1.ApprovalPolicyAutomatic {
+		fields = append(fields, "spec.approvalPolicy")
+	}
+	for _, annotation := range []string{webappv1alpha1.DryRunAnnotation, webappv1alpha1.RollbackAnnotation, webappv1alpha1.ApprovedVersionAnnotation} {
+		if _, ok := webapp.Annotations[annotation]; ok {
+			fields = append(fields, "the "+annotation+" annotation")
+		}
+	}
+	return fields
+}
+
+// webappFlags maps the spec of a Webapp to the flags of the deployment parameters, the empty fields being left to
+// the flag defaults like the CRD defaults would
+func webappFlags(webapp *webappv1alpha1.Webapp) map[string]string {
+	spec := webapp.Spec
+	values := map[string]string{
+		"tenantId":             spec.AzureTenantId,
+		"spnId":                spec.AzureSpnId,
+		"spnSecret":            spec.AzureSpnSecret,
+		"accountKey":           spec.AzureStorageAccountKey,
+		"storageName":          spec.StorageName,
+		"containerName":        spec.ContainerName,
+		"fileNameToCheck":      spec.FileNameToCheck,
+		"blobTagKey":           spec.BlobTagKey,
+		"versionToDeploy":      spec.VersionToDeploy,
+		"packageStorageName":   spec.PackageStorageName,
+		"packageContainerName": spec.PackageContainerName,
+		"packageAccountKey":    spec.AzurePackageStorageAccountKey,
+	}
+	if spec.VersionMarker != nil {
+		values["marker"] = string(spec.VersionMarker.Strategy)
+		values["markerFileName"] = spec.VersionMarker.FileName
+	}
+	if spec.Endpoint != nil {
+		values["cloud"] = string(spec.Endpoint.Cloud)
+		values["blobEndpoint"] = spec.Endpoint.BlobEndpoint
+		values["pathStyle"] = strconv.FormatBool(spec.Endpoint.PathStyle)
+	}
+	if spec.VersionPolicy != nil {
+		values["channel"] = spec.VersionPolicy.Channel
+	}
+	if spec.DeploymentTimeout != nil {
+		values["timeout"] = spec.DeploymentTimeout.Duration.String()
+	}
+
+	for name, value := range values {
+		if value == "" {
+			delete(values, name)
+		}
+	}
+	return values
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/morganleroi/deploy-website-k8s-operator/controllers/deploy"
+)
+
+func TestEnvName(t *testing.T) {
+	for flagName, expected := range map[string]string{
+		"spnSecret":       "WEBAPP_DEPLOY_SPN_SECRET",
+		"fileNameToCheck": "WEBAPP_DEPLOY_FILE_NAME_TO_CHECK",
+		"zap-log-level":   "WEBAPP_DEPLOY_ZAP_LOG_LEVEL",
+	} {
+		if name := envName(flagName); name != expected {
+			t.Errorf("%s: expecting %s, got %s", flagName, expected, name)
+		}
+	}
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	webappFile := filepath.Join(t.TempDir(), "webapp.yaml")
+	manifest := `apiVersion: webapp.simpletest.com/v1alpha1
+kind: Webapp
+metadata:
+  name: site
+spec:
+  storageName: file-storage
+  containerName: file-container
+  blobTagKey: file-key
+  versionToDeploy: v1
+`
+	if err := os.WriteFile(webappFile, []byte(manifest), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	parameters := deploy.InitParameters(flags)
+	flags.String("file", "", "")
+	if err := flags.Parse([]string{"-file", webappFile, "-storageName", "flag-storage"}); err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{"WEBAPP_DEPLOY_STORAGE_NAME": "env-storage", "WEBAPP_DEPLOY_CONTAINER_NAME": "env-container"}
+	lookupEnv := func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+	if err := loadConfig(flags, "file", lookupEnv); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, check := range []struct{ name, got, expected string }{
+		{"flag over env", *parameters.StorageName, "flag-storage"},
+		{"env over file", *parameters.ContainerName, "env-container"},
+		{"file over default", *parameters.BlobTagKey, "file-key"},
+		{"default when absent", *parameters.FileNameToCheck, "index.html"},
+	} {
+		if check.got != check.expected {
+			t.Errorf("%s: expecting %s, got %s", check.name, check.expected, check.got)
+		}
+	}
+}
+
+func TestLoadConfigUnsupportedFields(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		manifest string
+		// field is the field named by the error, empty when the manifest is accepted
+		field string
+	}{
+		{"channel", "spec:\n  versionPolicy:\n    channel: .master\n", ""},
+		{"automatic approval", "spec:\n  approvalPolicy: Automatic\n", ""},
+		{"latest version", "spec:\n  versionPolicy:\n    mode: Latest\n", "spec.versionPolicy.mode"},
+		{"constraint", "spec:\n  versionPolicy:\n    constraint: ~1.2\n", "spec.versionPolicy.constraint"},
+		{"targets", "spec:\n  targets:\n    - name: shell\n      path: shell\n", "spec.targets"},
+		{"blue/green", "spec:\n  blueGreen:\n    blue: blue\n    green: green\n", "spec.blueGreen"},
+		{"suspend", "spec:\n  suspend: true\n", "spec.suspend"},
+		{"dry run", "spec:\n  dryRun: true\n", "spec.dryRun"},
+		{"rollback", "spec:\n  rollbackTo: previous\n", "spec.rollbackTo"},
+		{"verification", "spec:\n  verification:\n    checks:\n      - path: /\n", "spec.verification"},
+		{"windows", "spec:\n  deploymentWindows:\n    - days: [Monday]\n      start: \"09:00\"\n      end: \"17:00\"\n", "spec.deploymentWindows"},
+		{"manual approval", "spec:\n  approvalPolicy: Manual\n", "spec.approvalPolicy"},
+		{"dry run annotation", "metadata:\n  annotations:\n    webapp.simpletest.com/dry-run: \"true\"\n", "webapp.simpletest.com/dry-run"},
+		{"rollback annotation", "metadata:\n  annotations:\n    webapp.simpletest.com/rollback: previous\n", "webapp.simpletest.com/rollback"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			webappFile := filepath.Join(t.TempDir(), "webapp.yaml")
+			if err := os.WriteFile(webappFile, []byte("kind: Webapp\n"+test.manifest), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			flags := flag.NewFlagSet("test", flag.ContinueOnError)
+			deploy.InitParameters(flags)
+			flags.String("file", "", "")
+			if err := flags.Parse([]string{"-file", webappFile}); err != nil {
+				t.Fatal(err)
+			}
+			err := loadConfig(flags, "file", func(string) (string, bool) { return "", false })
+			switch {
+			case test.field == "" && err != nil:
+				t.Fatalf("the manifest should be accepted: %v", err)
+			case test.field != "" && (err == nil || !strings.Contains(err.Error(), test.field)):
+				t.Fatalf("the manifest should be rejected naming %s, got %v", test.field, err)
+			}
+		})
+	}
+}
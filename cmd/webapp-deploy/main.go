@@ -0,0 +1,218 @@
+// Command webapp-deploy runs the deployments of the operator outside Kubernetes, for instance from a CI pipeline.
+// It shares the deploy package with the operator, a version deployed by one is seen as deployed by the other.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	"github.com/morganleroi/deploy-website-k8s-operator/controllers/deploy"
+)
+
+const usage = `Usage: webapp-deploy <command> [flags]
+
+Commands:
+  deploy    deploys -versionToDeploy, unless it is already deployed
+  status    prints the deployed version, fails when it is not -versionToDeploy if set
+  plan      prints the files deploying -versionToDeploy would add and change, and the stale files it leaves
+  rollback  deploys the package version preceding the deployed one, or -versionToDeploy
+
+Every flag can be set with its environment variable, e.g. WEBAPP_DEPLOY_SPN_SECRET for -spnSecret, or read from a
+Webapp manifest given with -file. Run "webapp-deploy <command> -h" to list the flags.
+`
+
+// defaultTimeout bounds a command, like the operator bounds a deployment
+const defaultTimeout = 15 * time.Minute
+
+// options are the parsed flags of a command
+type options struct {
+	parameters deploy.Parameters
+	channel    string
+	json       bool
+}
+
+type command func(ctx context.Context, options options, stdout io.Writer) error
+
+var commands = map[string]command{
+	"deploy":   runDeploy,
+	"status":   runStatus,
+	"plan":     runPlan,
+	"rollback": runRollback,
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr, os.LookupEnv))
+}
+
+// run executes the command line and returns the exit code: 1 when the command fails, 2 when it is invalid
+func run(args []string, stdout io.Writer, stderr io.Writer, lookupEnv func(string) (string, bool)) int {
+	if len(args) == 0 || commands[args[0]] == nil {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+	name := args[0]
+
+	flags := flag.NewFlagSet("webapp-deploy "+name, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	parameters := deploy.InitParameters(flags)
+	flags.String("file", "", "Webapp manifest to read the parameters from, the flags and the environment variables win over it")
+	timeout := flags.Duration("timeout", defaultTimeout, "Maximum duration of the command")
+	channel := flags.String("channel", "", "Suffix of the versions considered by rollback, e.g. .master")
+	jsonOutput := flags.Bool("json", false, "Print the status or the plan as JSON")
+	zapOptions := zap.Options{}
+	zapOptions.BindFlags(flags)
+
+	if err := flags.Parse(args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if err := loadConfig(flags, "file", lookupEnv); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	if *parameters.Prefix != "" && !strings.HasSuffix(*parameters.Prefix, "/") {
+		*parameters.Prefix += "/"
+	}
+
+	if _, missing := parameters.Validate(); len(missing) > 0 {
+		if name == "status" || name == "rollback" {
+			// The version to deploy is optional
+			missing = without(missing, "VersionToDeploy")
+		}
+		if len(missing) > 0 {
+			fmt.Fprintf(stderr, "missing parameters: %s\n", strings.Join(missing, ", "))
+			return 2
+		}
+	}
+
+	logger := zap.New(zap.UseFlagOptions(&zapOptions), zap.WriteTo(stderr))
+	ctrllog.SetLogger(logger)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctrllog.IntoContext(ctx, logger), *timeout)
+	defer cancel()
+
+	if err := commands[name](ctx, options{parameters: parameters, channel: *channel, json: *jsonOutput}, stdout); err != nil {
+		fmt.Fprintf(stderr, "%s failed: %v\n", name, err)
+		return 1
+	}
+	return 0
+}
+
+func without(values []string, value string) []string {
+	var kept []string
+	for _, v := range values {
+		if v != value {
+			kept = append(kept, v)
+		}
+	}
+	return kept
+}
+
+func runDeploy(ctx context.Context, options options, stdout io.Writer) error {
+	if err := deploy.StartDeployment(ctx, options.parameters, deploy.NoopObserver{}); err != nil {
+		return err
+	}
+	if err := deploy.VerifyDeployedVersion(ctx, options.parameters, deploy.NoopObserver{}); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "%s deployed\n", *options.parameters.VersionToDeploy)
+	return nil
+}
+
+type statusOutput struct {
+	DeployedVersion string `json:"deployedVersion"`
+	VersionToDeploy string `json:"versionToDeploy,omitempty"`
+	UpToDate        bool   `json:"upToDate"`
+}
+
+func runStatus(ctx context.Context, options options, stdout io.Writer) error {
+	deployedVersion, err := deploy.DeployedVersion(ctx, options.parameters, deploy.NoopObserver{})
+	if err != nil && !errors.Is(err, deploy.ErrNothingDeployed) {
+		return err
+	}
+
+	status := statusOutput{DeployedVersion: deployedVersion, VersionToDeploy: *options.parameters.VersionToDeploy}
+	status.UpToDate = status.VersionToDeploy == "" || status.VersionToDeploy == deployedVersion
+	if options.json {
+		if err := json.NewEncoder(stdout).Encode(status); err != nil {
+			return err
+		}
+	} else if deployedVersion != "" {
+		fmt.Fprintln(stdout, deployedVersion)
+	}
+
+	switch {
+	case !status.UpToDate && deployedVersion == "":
+		return fmt.Errorf("nothing is deployed instead of %s", status.VersionToDeploy)
+	case !status.UpToDate:
+		return fmt.Errorf("version %s is deployed instead of %s", deployedVersion, status.VersionToDeploy)
+	}
+	return nil
+}
+
+func runPlan(ctx context.Context, options options, stdout io.Writer) error {
+	plan, err := deploy.PlanDeployment(ctx, options.parameters, deploy.NoopObserver{})
+	if err != nil {
+		return err
+	}
+	if options.json {
+		return json.NewEncoder(stdout).Encode(plan)
+	}
+
+	fmt.Fprintf(stdout, "Deployed version: %s\nVersion to deploy: %s\n", plan.DeployedVersion, *options.parameters.VersionToDeploy)
+	for _, files := range []struct {
+		sign  string
+		names []string
+	}{{"+", plan.Added}, {"~", plan.Changed}, {"?", plan.Stale}} {
+		for _, fileName := range files.names {
+			fmt.Fprintf(stdout, "%s %s\n", files.sign, fileName)
+		}
+	}
+	fmt.Fprintf(stdout, "%d added, %d changed, %d unchanged, %d stale files left in place\n", len(plan.Added), len(plan.Changed), plan.Unchanged, len(plan.Stale))
+	return nil
+}
+
+// runRollback deploys -versionToDeploy whatever the deployed version, or the package version preceding the deployed one
+func runRollback(ctx context.Context, options options, stdout io.Writer) error {
+	parameters := options.parameters
+	deployedVersion, err := deploy.DeployedVersion(ctx, parameters, deploy.NoopObserver{})
+	if err != nil {
+		return err
+	}
+
+	version := *parameters.VersionToDeploy
+	if version == "" {
+		versions, err := deploy.ListPackageVersions(ctx, parameters, deploy.NoopObserver{})
+		if err != nil {
+			return err
+		}
+		if version, err = deploy.PreviousVersion(versions, deployedVersion, options.channel); err != nil {
+			return err
+		}
+	}
+
+	parameters.VersionToDeploy = &version
+	if err := deploy.DeployVersion(ctx, parameters, deploy.NoopObserver{}); err != nil {
+		return err
+	}
+	if err := deploy.VerifyDeployedVersion(ctx, parameters, deploy.NoopObserver{}); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "rolled back from %s to %s\n", deployedVersion, version)
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/morganleroi/deploy-website-k8s-operator/controllers/deploy/deploytest"
+)
+
+func TestCommands(t *testing.T) {
+	server := deploytest.NewBlobServer()
+	defer server.Close()
+	for _, version := range []string{"v1.0.0", "v1.1.0"} {
+		server.PutBlob(deploytest.AccountName, "packages", version+".zip", deploytest.Package(map[string]string{
+			"index.html": "<html><head><title>" + version + "</title></head></html>",
+		}), nil)
+	}
+
+	env := map[string]string{
+		"WEBAPP_DEPLOY_STORAGE_NAME":         deploytest.AccountName,
+		"WEBAPP_DEPLOY_PACKAGE_STORAGE_NAME": deploytest.AccountName,
+		"WEBAPP_DEPLOY_ACCOUNT_KEY":          deploytest.AccountKey,
+		"WEBAPP_DEPLOY_PACKAGE_ACCOUNT_KEY":  deploytest.AccountKey,
+		"WEBAPP_DEPLOY_BLOB_ENDPOINT":        server.URL,
+		"WEBAPP_DEPLOY_PATH_STYLE":           "true",
+	}
+	lookupEnv := func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+	runCommand := func(args ...string) (int, string) {
+		stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+		code := run(args, stdout, stderr, lookupEnv)
+		if code != 0 {
+			t.Logf("%v: %s", args, stderr)
+		}
+		return code, stdout.String()
+	}
+
+	if code, out := runCommand("status"); code != 0 || out != "" {
+		t.Fatalf("nothing should be deployed, got %d %q", code, out)
+	}
+	if code, _ := runCommand("deploy", "-versionToDeploy", "v1.0.0"); code != 0 {
+		t.Fatalf("deploy failed")
+	}
+	if code, out := runCommand("plan", "-versionToDeploy", "v1.1.0"); code != 0 || !strings.Contains(out, "~ index.html") {
+		t.Fatalf("unexpected plan %d %q", code, out)
+	}
+	if code, _ := runCommand("deploy", "-versionToDeploy", "v1.1.0"); code != 0 {
+		t.Fatalf("upgrade failed")
+	}
+	if code, out := runCommand("status", "-versionToDeploy", "v1.0.0"); code != 1 || out != "v1.1.0\n" {
+		t.Fatalf("status should report v1.1.0 instead of v1.0.0, got %d %q", code, out)
+	}
+	if code, out := runCommand("rollback"); code != 0 || out != "rolled back from v1.1.0 to v1.0.0\n" {
+		t.Fatalf("unexpected rollback %d %q", code, out)
+	}
+	if code, out := runCommand("status", "-json"); code != 0 || !strings.Contains(out, `"deployedVersion":"v1.0.0"`) {
+		t.Fatalf("unexpected status %d %q", code, out)
+	}
+	if code, _ := runCommand("unknown"); code != 2 {
+		t.Fatalf("an unknown command should be rejected")
+	}
+}
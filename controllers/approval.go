@@ -0,0 +1,66 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	webappv1alpha1 "github.com/morganleroi/deploy-website-k8s-operator/api/v1alpha1"
+	"github.com/morganleroi/deploy-website-k8s-operator/controllers/deploy"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// approved tells whether the version can be deployed: under the Manual policy a new version needs
+// the approval annotation carrying this exact version
+func approved(webapp *webappv1alpha1.Webapp, version string) bool {
+	if webapp.Spec.ApprovalPolicy != webappv1alpha1.ApprovalPolicyManual {
+		return true
+	}
+	if version == webapp.Status.DeployedVersion {
+		return true
+	}
+	return webapp.Annotations[webappv1alpha1.ApprovedVersionAnnotation] == version
+}
+
+// awaitApproval plans the pending version, once, and reports it with the PendingApproval condition.
+// The Webapp is reconciled again when the approval annotation is added.
+func (r *WebappReconciler) awaitApproval(ctx context.Context, webapp *webappv1alpha1.Webapp, deploymentParameters deploy.Parameters) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	version := *deploymentParameters.VersionToDeploy
+
+	if webapp.Status.Plan == nil || webapp.Status.Plan.Version != version {
+		if _, err := r.computePlan(ctx, webapp, deploymentParameters); err != nil {
+			if errStatusUpdate := r.Status().Update(ctx, webapp); errStatusUpdate != nil {
+				return ctrl.Result{}, errStatusUpdate
+			}
+			return ctrl.Result{}, err
+		}
+	}
+
+	condition := v1.Condition{
+		Type:    conditionPendingApproval,
+		Status:  v1.ConditionTrue,
+		Reason:  reasonAwaitingApproval,
+		Message: fmt.Sprintf("Version %s is waiting for approval, annotate the Webapp with %s=%s", version, webappv1alpha1.ApprovedVersionAnnotation, version),
+	}
+	if approvedVersion := webapp.Annotations[webappv1alpha1.ApprovedVersionAnnotation]; approvedVersion != "" && approvedVersion != webapp.Status.DeployedVersion {
+		condition.Reason = reasonStaleApproval
+		condition.Message = fmt.Sprintf("Approval for version %s does not match the pending version %s, annotate the Webapp with %s=%s",
+			approvedVersion, version, webappv1alpha1.ApprovedVersionAnnotation, version)
+	}
+
+	if current := meta.FindStatusCondition(webapp.Status.Conditions, conditionPendingApproval); current == nil || current.Message != condition.Message {
+		logger.Info("Deployment waiting for approval", "reason", condition.Reason)
+		eventType := corev1.EventTypeNormal
+		if condition.Reason == reasonStaleApproval {
+			eventType = corev1.EventTypeWarning
+		}
+		r.Recorder.Event(webapp, eventType, condition.Reason, condition.Message)
+	}
+
+	webapp.Status.Status = "PENDING_APPROVAL"
+	meta.SetStatusCondition(&webapp.Status.Conditions, condition)
+	return ctrl.Result{}, r.Status().Update(ctx, webapp)
+}
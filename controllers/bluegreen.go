@@ -0,0 +1,152 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	webappv1alpha1 "github.com/morganleroi/deploy-website-k8s-operator/api/v1alpha1"
+	"github.com/morganleroi/deploy-website-k8s-operator/controllers/deploy"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"strings"
+)
+
+// deploy deploys the version to the Webapp target, through the idle slot when blue/green is enabled, or to its targets.
+// A new version is verified when the Webapp defines a verification.
+func (r *WebappReconciler) deploy(ctx context.Context, webapp *webappv1alpha1.Webapp, deploymentParameters deploy.Parameters, observer deploy.Observer) error {
+	if len(webapp.Spec.Targets) > 0 {
+		return r.deployTargets(ctx, webapp, deploymentParameters, observer)
+	}
+	if webapp.Spec.BlueGreen == nil {
+		if err := deploy.StartDeployment(ctx, deploymentParameters, observer); err != nil {
+			return err
+		}
+		if webapp.Status.DeployedVersion == *deploymentParameters.VersionToDeploy {
+			return nil
+		}
+		return r.verifySite(ctx, webapp, *deploymentParameters.VersionToDeploy, "", observer)
+	}
+	return r.deployBlueGreen(ctx, webapp, deploymentParameters, observer)
+}
+
+// deployBlueGreen deploys the version to the idle slot, verifies it and makes it the active slot.
+// When the idle slot already holds the version, as after a rollback, the slots are switched right away.
+func (r *WebappReconciler) deployBlueGreen(ctx context.Context, webapp *webappv1alpha1.Webapp, deploymentParameters deploy.Parameters, observer deploy.Observer) error {
+	logger := log.FromContext(ctx)
+	version := *deploymentParameters.VersionToDeploy
+	blueGreen := webapp.Spec.BlueGreen
+
+	if err := validateBlueGreen(blueGreen); err != nil {
+		return err
+	}
+
+	active := webapp.Status.ActiveSlot
+	if active != "" && slotVersion(&webapp.Status, active) == version {
+		logger.Info("The version is already live. Nothing to do", "slot", active)
+		return nil
+	}
+
+	idle := idleSlot(active)
+	idleParameters := slotParameters(deploymentParameters, blueGreen, idle)
+	if slotVersion(&webapp.Status, idle) == version && deploy.VerifyDeployedVersion(ctx, idleParameters, observer) == nil {
+		logger.Info("The idle slot already holds the version, switching back", "slot", idle)
+	} else {
+		logger.Info("Deploying to the idle slot", "slot", idle)
+		if err := deploy.DeployVersion(ctx, idleParameters, observer); err != nil {
+			return err
+		}
+		if err := deploy.VerifyDeployedVersion(ctx, idleParameters, observer); err != nil {
+			return fmt.Errorf("verification of slot %s failed: %w", idle, err)
+		}
+		setSlotVersion(&webapp.Status, idle, version)
+	}
+
+	if blueGreen.Mode != webappv1alpha1.SlotModeContainers {
+		// The slot is reachable under its prefix, it is verified before receiving the traffic
+		if err := r.verifySite(ctx, webapp, version, idleParameters.BlobPrefix(), observer); err != nil {
+			return err
+		}
+	}
+
+	if err := r.switchSlot(ctx, webapp, deploymentParameters, idleParameters, idle, observer); err != nil {
+		return err
+	}
+	webapp.Status.ActiveSlot = idle
+	r.Recorder.Eventf(webapp, corev1.EventTypeNormal, reasonSlotSwitched, "Slot %s is live with version %s", idle, version)
+
+	if blueGreen.Mode == webappv1alpha1.SlotModeContainers {
+		return r.verifySite(ctx, webapp, version, "", observer)
+	}
+	return nil
+}
+
+// switchSlot moves the traffic to the slot
+func (r *WebappReconciler) switchSlot(ctx context.Context, webapp *webappv1alpha1.Webapp, deploymentParameters deploy.Parameters, slotParameters deploy.Parameters, slot string, observer deploy.Observer) error {
+	if webapp.Spec.BlueGreen.Switch == webappv1alpha1.SwitchHook {
+		return deploy.CallSwitchHook(ctx, deploymentParameters, webapp.Spec.BlueGreen.HookUrl, deploy.SwitchHook{
+			Namespace:     webapp.Namespace,
+			Webapp:        webapp.Name,
+			Slot:          slot,
+			Version:       *slotParameters.VersionToDeploy,
+			StorageName:   *slotParameters.StorageName,
+			ContainerName: *slotParameters.ContainerName,
+			Prefix:        slotParameters.BlobPrefix(),
+		}, observer)
+	}
+	return deploy.WritePointer(ctx, deploymentParameters, slotParameters.BlobPrefix(), observer)
+}
+
+func validateBlueGreen(blueGreen *webappv1alpha1.BlueGreen) error {
+	if blueGreen.Blue == "" || blueGreen.Green == "" || blueGreen.Blue == blueGreen.Green {
+		return fmt.Errorf("blue/green slots must be two different prefixes or containers")
+	}
+	if blueGreen.Switch == webappv1alpha1.SwitchHook && blueGreen.HookUrl == "" {
+		return fmt.Errorf("the Hook switch requires a hookUrl")
+	}
+	if blueGreen.Switch != webappv1alpha1.SwitchHook && blueGreen.Mode == webappv1alpha1.SlotModeContainers {
+		return fmt.Errorf("the Pointer switch requires the Prefixes mode")
+	}
+	return nil
+}
+
+// idleSlot returns the slot receiving the next version, blue for the first deployment
+func idleSlot(active string) string {
+	if active == webappv1alpha1.SlotBlue {
+		return webappv1alpha1.SlotGreen
+	}
+	return webappv1alpha1.SlotBlue
+}
+
+// slotParameters targets the deployment parameters on a slot, either a container or a prefix of the container
+func slotParameters(deploymentParameters deploy.Parameters, blueGreen *webappv1alpha1.BlueGreen, slot string) deploy.Parameters {
+	target := blueGreen.Blue
+	if slot == webappv1alpha1.SlotGreen {
+		target = blueGreen.Green
+	}
+
+	if blueGreen.Mode == webappv1alpha1.SlotModeContainers {
+		deploymentParameters.ContainerName = &target
+		return deploymentParameters
+	}
+	prefix := strings.Trim(target, "/") + "/"
+	deploymentParameters.Prefix = &prefix
+	return deploymentParameters
+}
+
+func slotVersion(status *webappv1alpha1.WebappStatus, slot string) string {
+	for _, slotStatus := range status.Slots {
+		if slotStatus.Name == slot {
+			return slotStatus.Version
+		}
+	}
+	return ""
+}
+
+func setSlotVersion(status *webappv1alpha1.WebappStatus, slot string, version string) {
+	for i := range status.Slots {
+		if status.Slots[i].Name == slot {
+			status.Slots[i].Version = version
+			return
+		}
+	}
+	status.Slots = append(status.Slots, webappv1alpha1.SlotStatus{Name: slot, Version: version})
+}
@@ -0,0 +1,45 @@
+package controllers
+
+// Condition types reported in the Webapp status
+const (
+	conditionAvailable        = "Available"
+	conditionDegraded         = "Degraded"
+	conditionProgressing      = "Progressing"
+	conditionTargetConflict   = "TargetConflict"
+	conditionPendingApproval  = "PendingApproval"
+	conditionWaitingForWindow = "WaitingForWindow"
+	conditionSuspended        = "Suspended"
+)
+
+// Condition reasons reported in the Webapp status
+const (
+	reasonDeployed                = "Deployed"
+	reasonRolledBack              = "RolledBack"
+	reasonDeploymentFailed        = "DeploymentFailed"
+	reasonRollbackFailed          = "RollbackFailed"
+	reasonTimeout                 = "Timeout"
+	reasonCancelled               = "Cancelled"
+	reasonTargetLocked            = "TargetLocked"
+	reasonTargetClaimed           = "TargetClaimed"
+	reasonPlanned                 = "Planned"
+	reasonPlanFailed              = "PlanFailed"
+	reasonAwaitingApproval        = "AwaitingApproval"
+	reasonStaleApproval           = "StaleApproval"
+	reasonApproved                = "Approved"
+	reasonOutsideWindow           = "OutsideWindow"
+	reasonInWindow                = "InWindow"
+	reasonInvalidWindows          = "InvalidWindows"
+	reasonInvalidFreeze           = "InvalidFreeze"
+	reasonVersionResolved         = "VersionResolved"
+	reasonVersionResolutionFailed = "VersionResolutionFailed"
+	reasonMissingVersion          = "MissingVersion"
+	reasonExpired                 = "Expired"
+	reasonWebappNotFound          = "WebappNotFound"
+	reasonCleanupSkipped          = "CleanupSkipped"
+	reasonVerificationFailed      = "VerificationFailed"
+	reasonSlotSwitched            = "SlotSwitched"
+	reasonLocationClaimed         = "LocationClaimed"
+	reasonSuspendedBySpec         = "SuspendedBySpec"
+	reasonSuspendedByOperator     = "SuspendedByOperator"
+	reasonResumed                 = "Resumed"
+)
@@ -0,0 +1,82 @@
+package controllers
+
+import (
+	"context"
+	webappv1alpha1 "github.com/morganleroi/deploy-website-k8s-operator/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sort"
+)
+
+// targetIndexKey indexes the Webapps by deployment target to find the ones sharing a container
+const targetIndexKey = ".spec.target"
+
+func indexWebappTarget(object client.Object) []string {
+	return deploymentTargets(object.(*webappv1alpha1.Webapp))
+}
+
+// deploymentTargets lists every container a Webapp deploys to, sorted so they are always locked in the same order
+func deploymentTargets(webapp *webappv1alpha1.Webapp) []string {
+	var targets []string
+	seen := map[string]bool{}
+	for _, location := range deployedLocations(webapp) {
+		target := deploymentTarget(location.storageName, location.containerName)
+		if !seen[target] {
+			seen[target] = true
+			targets = append(targets, target)
+		}
+	}
+	sort.Strings(targets)
+	return targets
+}
+
+// targetOwner returns the Webapp allowed to deploy to the target of the given one: the oldest Webapp claiming it,
+// whatever its namespace. Webapps being deleted do not claim their target anymore.
+func (r *WebappReconciler) targetOwner(ctx context.Context, webapp *webappv1alpha1.Webapp, target string) (types.NamespacedName, error) {
+	webapps := &webappv1alpha1.WebappList{}
+	if err := r.List(ctx, webapps, client.MatchingFields{targetIndexKey: target}); err != nil {
+		return types.NamespacedName{}, err
+	}
+
+	owner := webapp
+	for i := range webapps.Items {
+		candidate := &webapps.Items[i]
+		if candidate.DeletionTimestamp != nil {
+			continue
+		}
+		if claimedBefore(candidate, owner) {
+			owner = candidate
+		}
+	}
+	return client.ObjectKeyFromObject(owner), nil
+}
+
+// claimedBefore orders the Webapps by creation, using the namespaced name to break ties
+func claimedBefore(webapp *webappv1alpha1.Webapp, other *webappv1alpha1.Webapp) bool {
+	if !webapp.CreationTimestamp.Equal(&other.CreationTimestamp) {
+		return webapp.CreationTimestamp.Before(&other.CreationTimestamp)
+	}
+	return client.ObjectKeyFromObject(webapp).String() < client.ObjectKeyFromObject(other).String()
+}
+
+// webappsSharingTarget enqueues the Webapps targeting one of the containers of the given one,
+// so that a conflicting Webapp is deployed as soon as the owner of its target is deleted or moves to another target.
+func (r *WebappReconciler) webappsSharingTarget(object client.Object) []reconcile.Request {
+	var requests []reconcile.Request
+	enqueued := map[types.NamespacedName]bool{client.ObjectKeyFromObject(object): true}
+	for _, target := range indexWebappTarget(object) {
+		webapps := &webappv1alpha1.WebappList{}
+		if err := r.List(context.Background(), webapps, client.MatchingFields{targetIndexKey: target}); err != nil {
+			return nil
+		}
+		for _, webapp := range webapps.Items {
+			key := client.ObjectKeyFromObject(&webapp)
+			if !enqueued[key] {
+				enqueued[key] = true
+				requests = append(requests, reconcile.Request{NamespacedName: key})
+			}
+		}
+	}
+	return requests
+}
@@ -0,0 +1,238 @@
+package deploy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"net/http"
+)
+
+// azureStorage authenticates the requests made on the package and the target storage accounts,
+// with the account key of a storage account when given, with the SPN otherwise
+type azureStorage struct {
+	spn         azcore.TokenCredential
+	accountKeys map[string]string
+	endpoint    *Endpoint
+}
+
+// NewAzureStorage is the default StorageFactory, it accesses the Azure blob service of the parameters endpoint
+func NewAzureStorage(deploymentParams Parameters) (Storage, error) {
+	if err := deploymentParams.Endpoint.Validate(); err != nil {
+		return nil, err
+	}
+
+	storage := &azureStorage{accountKeys: deploymentParams.accountKeys(), endpoint: deploymentParams.Endpoint}
+	if !deploymentParams.requiresSpn() {
+		return storage, nil
+	}
+
+	spn, err := deploymentParams.Dependencies.credentials()(deploymentParams)
+	if err != nil {
+		return nil, err
+	}
+	storage.spn = spn
+	return storage, nil
+}
+
+// NewSpnCredential is the default CredentialProvider, it authenticates with the SPN secret in the cloud of the parameters endpoint
+func NewSpnCredential(deploymentParams Parameters) (azcore.TokenCredential, error) {
+	spn, err := azidentity.NewClientSecretCredential(*deploymentParams.TenantId, *deploymentParams.SpnId, *deploymentParams.SpnSecret, &azidentity.ClientSecretCredentialOptions{
+		ClientOptions: azcore.ClientOptions{Transport: httpClient, Cloud: cloudConfigurations[deploymentParams.Endpoint.cloud()]},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to generate a secret credential %w", err)
+	}
+	return spn, nil
+}
+
+// serviceClient creates a client of the blob service of the storage account
+func (storage *azureStorage) serviceClient(storageName string) (*azblob.ServiceClient, error) {
+	serviceUrl := storage.endpoint.serviceUrl(storageName)
+	if accountKey, ok := storage.accountKeys[storageName]; ok {
+		sharedKey, err := azblob.NewSharedKeyCredential(storageName, accountKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid account key for storage account %s: %w", storageName, err)
+		}
+		return azblob.NewServiceClientWithSharedKey(serviceUrl, sharedKey, clientOptions())
+	}
+	return azblob.NewServiceClient(serviceUrl, storage.spn, clientOptions())
+}
+
+// Container creates a client of a container of the storage account
+func (storage *azureStorage) Container(storageName string, containerName string) (Container, error) {
+	serviceClient, err := storage.serviceClient(storageName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create a storage account client for %s with error %w", storageName, err)
+	}
+
+	client, err := serviceClient.NewContainerClient(containerName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get %s container on storage account %s with error: %w", containerName, storageName, err)
+	}
+	return azureContainer{client: client}, nil
+}
+
+// azureContainer maps the Container operations to the blob REST API
+type azureContainer struct {
+	client *azblob.ContainerClient
+}
+
+func (container azureContainer) List(ctx context.Context, prefix string, withTags bool) ([]BlobProperties, error) {
+	options := &azblob.ContainerListBlobsFlatOptions{Prefix: &prefix}
+	if withTags {
+		options.Include = []azblob.ListBlobsIncludeItem{azblob.ListBlobsIncludeItemTags}
+	}
+
+	var blobs []BlobProperties
+	pager := container.client.ListBlobsFlat(options)
+	for pager.NextPage(ctx) {
+		for _, item := range pager.PageResponse().ListBlobsFlatSegmentResponse.Segment.BlobItems {
+			blob := BlobProperties{Name: *item.Name, Metadata: make(map[string]string)}
+			if item.Properties != nil {
+				blob.ETag = stringValue(item.Properties.Etag)
+				blob.ContentMD5 = item.Properties.ContentMD5
+				blob.ContentType = stringValue(item.Properties.ContentType)
+				blob.CacheControl = stringValue(item.Properties.CacheControl)
+				if item.Properties.LastModified != nil {
+					blob.LastModified = *item.Properties.LastModified
+				}
+			}
+			for key, value := range item.Metadata {
+				blob.Metadata[key] = stringValue(value)
+			}
+			if item.BlobTags != nil {
+				blob.Tags = tagMap(item.BlobTags.BlobTagSet)
+				blob.TagCount = len(blob.Tags)
+			}
+			blobs = append(blobs, blob)
+		}
+	}
+	if err := pager.Err(); err != nil {
+		return nil, err
+	}
+	return blobs, nil
+}
+
+func (container azureContainer) GetProperties(ctx context.Context, name string) (BlobProperties, error) {
+	blobClient, err := container.client.NewBlobClient(name)
+	if err != nil {
+		return BlobProperties{}, err
+	}
+	properties, err := blobClient.GetProperties(ctx, nil)
+	if err != nil {
+		return BlobProperties{}, storageError(err)
+	}
+
+	blob := BlobProperties{
+		Name:         name,
+		ETag:         stringValue(properties.ETag),
+		ContentMD5:   properties.ContentMD5,
+		ContentType:  stringValue(properties.ContentType),
+		CacheControl: stringValue(properties.CacheControl),
+		Metadata:     properties.Metadata,
+	}
+	if properties.LastModified != nil {
+		blob.LastModified = *properties.LastModified
+	}
+	if properties.TagCount != nil {
+		blob.TagCount = int(*properties.TagCount)
+	}
+	return blob, nil
+}
+
+func (container azureContainer) GetTags(ctx context.Context, name string) (map[string]string, error) {
+	blobClient, err := container.client.NewBlobClient(name)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := blobClient.GetTags(ctx, nil)
+	if err != nil {
+		return nil, storageError(err)
+	}
+	return tagMap(tags.BlobTagSet), nil
+}
+
+func (container azureContainer) Download(ctx context.Context, name string) ([]byte, error) {
+	blobClient, err := container.client.NewBlobClient(name)
+	if err != nil {
+		return nil, err
+	}
+	get, err := blobClient.Download(ctx, nil)
+	if err != nil {
+		return nil, storageError(err)
+	}
+	reader := get.Body(&azblob.RetryReaderOptions{})
+	defer reader.Close()
+
+	content := &bytes.Buffer{}
+	if _, err := content.ReadFrom(reader); err != nil {
+		return nil, err
+	}
+	return content.Bytes(), nil
+}
+
+func (container azureContainer) Upload(ctx context.Context, name string, content []byte, options UploadOptions) error {
+	blobClient, err := container.client.NewBlockBlobClient(name)
+	if err != nil {
+		return err
+	}
+
+	uploadOptions := azblob.UploadOption{Metadata: options.Metadata, TagsMap: options.Tags}
+	if options.ContentType != "" || options.CacheControl != "" {
+		uploadOptions.HTTPHeaders = &azblob.BlobHTTPHeaders{}
+		if options.ContentType != "" {
+			uploadOptions.HTTPHeaders.BlobContentType = &options.ContentType
+		}
+		if options.CacheControl != "" {
+			uploadOptions.HTTPHeaders.BlobCacheControl = &options.CacheControl
+		}
+	}
+	_, err = blobClient.UploadBuffer(ctx, content, uploadOptions)
+	return err
+}
+
+func (container azureContainer) Delete(ctx context.Context, name string) error {
+	blobClient, err := container.client.NewBlobClient(name)
+	if err != nil {
+		return err
+	}
+	deleteSnapshots := azblob.DeleteSnapshotsOptionTypeInclude
+	_, err = blobClient.Delete(ctx, &azblob.BlobDeleteOptions{DeleteSnapshots: &deleteSnapshots})
+	return storageError(err)
+}
+
+// storageError maps the storage errors the deployment handles to ErrBlobNotFound and ErrTagsNotSupported
+func storageError(err error) error {
+	var storageError *azblob.StorageError
+	if !errors.As(err, &storageError) {
+		return err
+	}
+	if storageError.StatusCode() == http.StatusNotFound {
+		return fmt.Errorf("%w: %v", ErrBlobNotFound, err)
+	}
+	// Some emulators and proxies do not serve the tags of a single blob, listing them is still supported
+	if storageError.StatusCode() == http.StatusNotImplemented || storageError.ErrorCode == azblob.StorageErrorCodeFeatureVersionMismatch ||
+		storageError.ErrorCode == azblob.StorageErrorCodeUnsupportedQueryParameter {
+		return fmt.Errorf("%w: %v", ErrTagsNotSupported, err)
+	}
+	return err
+}
+
+func tagMap(tags []*azblob.BlobTag) map[string]string {
+	tagMap := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		tagMap[stringValue(tag.Key)] = stringValue(tag.Value)
+	}
+	return tagMap
+}
+
+func stringValue(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
@@ -6,63 +6,74 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
-	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sort"
+	"strings"
 )
 
-func GetDeployedPackageVersion(deploymentParams Parameters, azureClientSecret *azidentity.ClientSecretCredential) (string, error) {
-	defer declareNewStep("Checking current deployed version")()
+// ErrNothingDeployed is returned when the target has no version marker yet, before its first deployment
+var ErrNothingDeployed = errors.New("nothing deployed")
 
-	fmt.Printf("Trying to find the value of the blobKey '%s' in the '%s' file located in the storage '%s/%s'\n", *deploymentParams.BlobTagKey, *deploymentParams.FileNameToCheck, *deploymentParams.StorageName, *deploymentParams.ContainerName)
+func GetDeployedPackageVersion(ctx context.Context, deploymentParams Parameters, storage Storage, observer Observer) (version string, err error) {
+	ctx, endStep := declareNewStep(ctx, deploymentParams.clock(), observer, StepVersionCheck, &err)
+	defer endStep()
+	logger := log.FromContext(ctx).WithValues("step", StepVersionCheck)
 
-	serviceClient, err := azblob.NewServiceClient(fmt.Sprintf("https://%s.%s/", *deploymentParams.StorageName, AzureBlobDomain), azureClientSecret, nil)
+	marker, err := NewVersionMarker(deploymentParams)
 	if err != nil {
-		return "", fmt.Errorf("unable to create a storage account client for %s with error %v\n", *deploymentParams.ContainerName, err)
+		return "", err
 	}
+	logger.V(1).Info("Looking for the deployed version", "marker", deploymentParams.MarkerStrategy(), "blobTagKey", *deploymentParams.BlobTagKey, "file", deploymentParams.BlobPrefix()+*deploymentParams.FileNameToCheck, "container", *deploymentParams.ContainerName)
 
-	client, err := serviceClient.NewContainerClient(*deploymentParams.ContainerName)
+	client, err := storage.Container(*deploymentParams.StorageName, *deploymentParams.ContainerName)
 	if err != nil {
-		return "", fmt.Errorf("unable to get %s container on storage account %s with error: %v\n", *deploymentParams.ContainerName, *deploymentParams.StorageName, err)
-	}
-
-	pager := client.ListBlobsFlat(&azblob.ContainerListBlobsFlatOptions{
-		Include: []azblob.ListBlobsIncludeItem{"metadata", "tags"},
-	})
-
-	for pager.NextPage(context.Background()) {
-		resp := pager.PageResponse()
-		for _, v := range resp.ListBlobsFlatSegmentResponse.Segment.BlobItems {
-			if *v.Name == *deploymentParams.FileNameToCheck {
-				if v.BlobTags == nil {
-					return "", errors.New(fmt.Sprintf("Unable to find %s tag in %s file (Container %s Storage Account %s)\n", *deploymentParams.BlobTagKey, *deploymentParams.FileNameToCheck, *deploymentParams.ContainerName, *deploymentParams.StorageName))
-				}
-
-				for _, tag := range v.BlobTags.BlobTagSet {
-					if *tag.Key == *deploymentParams.BlobTagKey {
-						fmt.Printf("Successfully found a blobKey '%s' in '%s/%s/%s' with the value %s\n", *deploymentParams.BlobTagKey, *deploymentParams.StorageName, *deploymentParams.ContainerName, *deploymentParams.FileNameToCheck, *tag.Value)
-						return *tag.Value, nil
-					}
-				}
-				return "", errors.New(fmt.Sprintf("Unable to find %s tag in %s file (Container %s Storage Account %s)\n", *deploymentParams.BlobTagKey, *deploymentParams.FileNameToCheck, *deploymentParams.ContainerName, *deploymentParams.StorageName))
-			}
+		return "", err
+	}
+
+	// A single request on the marker blob, whatever the size of the container
+	properties, err := client.GetProperties(ctx, marker.MarkerBlob())
+	if err != nil {
+		if errors.Is(err, ErrBlobNotFound) {
+			return "", fmt.Errorf("unable to find %s file in container %s (%s): %w", marker.MarkerBlob(), *deploymentParams.ContainerName, *deploymentParams.StorageName, ErrNothingDeployed)
 		}
+		return "", fmt.Errorf("unable to get %s file properties in container %s (%s) with error: %w", marker.MarkerBlob(), *deploymentParams.ContainerName, *deploymentParams.StorageName, err)
 	}
-	return "", errors.New(fmt.Sprintf("Unable to find %s file in container %s (%s)", *deploymentParams.FileNameToCheck, *deploymentParams.ContainerName, *deploymentParams.StorageName))
+
+	cacheKey := fmt.Sprintf("%s/%s/%s/%s/%s", *deploymentParams.StorageName, *deploymentParams.ContainerName, marker.MarkerBlob(), deploymentParams.MarkerStrategy(), *deploymentParams.BlobTagKey)
+	if version, ok := deploymentParams.VersionCache.lookup(cacheKey, properties.ETag); ok {
+		logger.Info("Found deployed version", "deployedVersion", version, "cached", true)
+		return version, nil
+	}
+
+	version, err = marker.ReadVersion(ctx, client, properties)
+	if err != nil {
+		return "", fmt.Errorf("%s (Container %s Storage Account %s)", err.Error(), *deploymentParams.ContainerName, *deploymentParams.StorageName)
+	}
+	deploymentParams.VersionCache.store(cacheKey, properties.ETag, version)
+	logger.Info("Found deployed version", "deployedVersion", version)
+	return version, nil
 }
 
-func Deploy(deploymentParameters Parameters, azureClientSecret *azidentity.ClientSecretCredential) error {
+func Deploy(ctx context.Context, deploymentParameters Parameters, storage Storage, observer Observer) error {
+
+	downloadedData, err := downloadPackage(ctx, deploymentParameters, storage, observer)
+	if err != nil {
+		return err
+	}
 
-	downloadedData, err := downloadPackage(deploymentParameters, azureClientSecret)
+	extractedFiles, err := extractPackage(ctx, deploymentParameters, downloadedData, observer)
 	if err != nil {
 		return err
 	}
 
-	extractedFiles, err := extractPackage(downloadedData)
+	extractedFiles, err = packageFiles(deploymentParameters, extractedFiles)
 	if err != nil {
 		return err
 	}
 
-	err = deployPackage(deploymentParameters, extractedFiles, azureClientSecret)
+	err = deployPackage(ctx, deploymentParameters, extractedFiles, storage, observer)
 	if err != nil {
 		return err
 	}
@@ -70,83 +81,162 @@ func Deploy(deploymentParameters Parameters, azureClientSecret *azidentity.Clien
 	return nil
 }
 
-func downloadPackage(deploymentParameters Parameters, azureClientSecret *azidentity.ClientSecretCredential) (*bytes.Buffer, error) {
-	defer declareNewStep("Download package to deploy")()
+func downloadPackage(ctx context.Context, deploymentParameters Parameters, storage Storage, observer Observer) (downloadedData *bytes.Buffer, err error) {
+	ctx, endStep := declareNewStep(ctx, deploymentParameters.clock(), observer, StepDownload, &err)
+	defer endStep()
+	logger := log.FromContext(ctx).WithValues("step", StepDownload)
 
 	zipName := fmt.Sprintf("%s%s.zip", deploymentParameters.PackageUrl(), *deploymentParameters.VersionToDeploy)
 
-	fmt.Printf("Trying to fetch %s\n", zipName)
-
-	blobClient, err := azblob.NewBlockBlobClient(zipName, azureClientSecret, nil)
-	if err != nil {
-		return nil, fmt.Errorf("unable to get %s file package metadata before download with error: %v", zipName, err)
-	}
+	logger.V(1).Info("Downloading package", "package", zipName)
 
-	get, err := blobClient.Download(context.Background(), nil)
+	client, err := storage.Container(*deploymentParameters.Package.StorageName, *deploymentParameters.Package.ContainerName)
 	if err != nil {
-		return nil, fmt.Errorf("unable to download %s file package with error: %v", zipName, err)
+		return nil, err
 	}
 
-	downloadedData := &bytes.Buffer{}
-	reader := get.Body(&azblob.RetryReaderOptions{})
-	_, err = downloadedData.ReadFrom(reader)
-	if err != nil {
-		return nil, fmt.Errorf("unable to download %s file package with error: %v", zipName, err)
-	}
-	err = reader.Close()
+	content, err := client.Download(ctx, *deploymentParameters.VersionToDeploy+".zip")
 	if err != nil {
-		return nil, fmt.Errorf("unable to download %s file package with error: %v", zipName, err)
+		return nil, fmt.Errorf("unable to download %s file package with error: %w", zipName, err)
 	}
+	downloadedData = bytes.NewBuffer(content)
 
+	logger.Info("Package downloaded", "package", zipName, "size", downloadedData.Len())
+	observer.PackageDownloaded(int64(downloadedData.Len()))
 	return downloadedData, nil
 }
 
-func extractPackage(downloadedData *bytes.Buffer) (map[string]*bytes.Buffer, error) {
-	defer declareNewStep("Extracting package")()
+func extractPackage(ctx context.Context, deploymentParameters Parameters, downloadedData *bytes.Buffer, observer Observer) (extractedFiles map[string]*bytes.Buffer, err error) {
+	ctx, endStep := declareNewStep(ctx, deploymentParameters.clock(), observer, StepExtract, &err)
+	defer endStep()
+	logger := log.FromContext(ctx).WithValues("step", StepExtract)
 
 	unzippedPackage := downloadedData.Bytes()
 	newReader := bytes.NewReader(unzippedPackage)
-	decompressor, _ := zip.NewReader(newReader, int64(len(unzippedPackage)))
+	decompressor, err := zip.NewReader(newReader, int64(len(unzippedPackage)))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the zip package with error: %w", err)
+	}
 
-	extractedFiles := make(map[string]*bytes.Buffer)
+	extractedFiles = make(map[string]*bytes.Buffer)
 	for _, file := range decompressor.File {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("package extraction interrupted: %w", ctx.Err())
+		}
+
 		open, err := file.Open()
 		downloadedData := &bytes.Buffer{}
 		_, err = downloadedData.ReadFrom(open)
 
 		if err != nil {
-			return nil, fmt.Errorf("unable to read and extract file %s file from zip package with error: %v", file.Name, err)
+			return nil, fmt.Errorf("unable to read and extract file %s file from zip package with error: %w", file.Name, err)
 		}
 
 		extractedFiles[file.Name] = downloadedData
+		logger.V(1).Info("File extracted", "file", file.Name, "size", downloadedData.Len())
 	}
 
-	fmt.Printf("Package extracted (%d files / %d)\n", len(extractedFiles), len(decompressor.File))
+	logger.Info("Package extracted", "files", len(extractedFiles), "entries", len(decompressor.File))
 	return extractedFiles, nil
 }
 
-func deployPackage(deploymentParameters Parameters, extractedFiles map[string]*bytes.Buffer, azureClientSecret *azidentity.ClientSecretCredential) error {
+// packageFiles returns the files of the package directory to deploy, named relatively to the directory
+func packageFiles(deploymentParameters Parameters, extractedFiles map[string]*bytes.Buffer) (map[string]*bytes.Buffer, error) {
+	directory := deploymentParameters.PackageDirectory()
+	files := make(map[string]*bytes.Buffer, len(extractedFiles))
+	for fileName, content := range extractedFiles {
+		if !strings.HasPrefix(fileName, directory) || fileName == directory {
+			continue
+		}
+		// The buffers are copied, the files of a package are deployed to several targets
+		files[strings.TrimPrefix(fileName, directory)] = bytes.NewBuffer(content.Bytes())
+	}
+	if directory != "" && len(files) == 0 {
+		return nil, fmt.Errorf("invalid package: directory %s holds no file", directory)
+	}
+	return files, nil
+}
+
+func deployPackage(ctx context.Context, deploymentParameters Parameters, extractedFiles map[string]*bytes.Buffer, storage Storage, observer Observer) (err error) {
 	url := deploymentParameters.StorageUrl()
 
-	defer declareNewStep("Uploading files")()
+	ctx, endStep := declareNewStep(ctx, deploymentParameters.clock(), observer, StepUpload, &err)
+	defer endStep()
+	logger := log.FromContext(ctx).WithValues("step", StepUpload)
 
-	ctx := context.Background()
+	marker, err := NewVersionMarker(deploymentParameters)
+	if err != nil {
+		return err
+	}
 
-	for fileName, content := range extractedFiles {
-		blobClient, err := azblob.NewBlockBlobClient(fmt.Sprintf("%s%s", url, fileName), azureClientSecret, nil)
+	fileNames := make([]string, 0, len(extractedFiles))
+	for fileName := range extractedFiles {
+		fileNames = append(fileNames, fileName)
+	}
+	sort.Strings(fileNames)
+
+	for start := 0; start < len(fileNames); start += uploadBatchSize {
+		end := start + uploadBatchSize
+		if end > len(fileNames) {
+			end = len(fileNames)
+		}
+
+		err = uploadBatch(ctx, deploymentParameters, marker, fileNames[start:end], extractedFiles, storage, observer)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The marker files are written last, they must not report the version while the package is partially deployed
+	markerFiles := marker.MarkerFiles()
+	if len(markerFiles) > 0 {
+		fileNames = make([]string, 0, len(markerFiles))
+		for fileName, content := range markerFiles {
+			fileName = strings.TrimPrefix(fileName, deploymentParameters.BlobPrefix())
+			fileNames = append(fileNames, fileName)
+			extractedFiles[fileName] = bytes.NewBuffer(content)
+		}
+		err = uploadBatch(ctx, deploymentParameters, marker, fileNames, extractedFiles, storage, observer)
 		if err != nil {
-			return fmt.Errorf("unable to upload %s file in storage %s with error: %v", fileName, url, err)
+			return err
+		}
+	}
+	logger.Info("Package uploaded", "url", url, "files", len(extractedFiles))
+	return nil
+}
+
+// uploadBatch uploads a set of files, it is traced as a single span to keep traces readable on large packages
+func uploadBatch(ctx context.Context, deploymentParameters Parameters, marker VersionMarker, fileNames []string, extractedFiles map[string]*bytes.Buffer, storage Storage, observer Observer) (err error) {
+	url := deploymentParameters.StorageUrl()
+
+	ctx, span := tracer.Start(ctx, "UploadBatch", trace.WithAttributes(
+		attribute.Int("files", len(fileNames)),
+		attribute.String("firstFile", fileNames[0]),
+	))
+	defer func() {
+		endSpan(span, err)
+	}()
+	logger := log.FromContext(ctx).WithValues("step", StepUpload)
+
+	client, err := storage.Container(*deploymentParameters.StorageName, *deploymentParameters.ContainerName)
+	if err != nil {
+		return err
+	}
+
+	for _, fileName := range fileNames {
+		if ctx.Err() != nil {
+			return fmt.Errorf("upload to storage %s interrupted before %s file: %w", url, fileName, ctx.Err())
 		}
 
-		_, err = blobClient.UploadBuffer(ctx, content.Bytes(), azblob.UploadOption{
-			TagsMap: map[string]string{
-				*deploymentParameters.BlobTagKey: *deploymentParameters.VersionToDeploy,
-			},
-		})
+		blobName := deploymentParameters.BlobPrefix() + fileName
+		options := UploadOptions{}
+		content := marker.MarkUpload(blobName, extractedFiles[fileName].Bytes(), &options)
+		err = client.Upload(ctx, blobName, content, options)
 		if err != nil {
-			return fmt.Errorf("unable to upload %s file in storage %s with error: %v", fileName, url, err)
+			return fmt.Errorf("unable to upload %s file in storage %s with error: %w", fileName, url, err)
 		}
+		logger.V(1).Info("File uploaded", "file", fileName, "size", len(content))
+		observer.FileUploaded(fileName, int64(len(content)))
 	}
-	fmt.Printf("Package deployed with success to %s\n (%d files)", url, len(extractedFiles))
 	return nil
 }
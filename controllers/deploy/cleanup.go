@@ -0,0 +1,47 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// DeleteDeployedFiles deletes the files of the target container under the parameters prefix, which is required
+func DeleteDeployedFiles(ctx context.Context, deploymentParams Parameters, observer Observer) (err error) {
+	ctx, endStep := declareNewStep(ctx, deploymentParams.clock(), observer, StepCleanup, &err)
+	defer endStep()
+	logger := log.FromContext(ctx).WithValues("step", StepCleanup)
+
+	if deploymentParams.BlobPrefix() == "" {
+		return fmt.Errorf("refusing to delete every file of container %s (%s): a prefix is required", *deploymentParams.ContainerName, *deploymentParams.StorageName)
+	}
+
+	storage, err := deploymentParams.storage()
+	if err != nil {
+		return err
+	}
+
+	client, err := storage.Container(*deploymentParams.StorageName, *deploymentParams.ContainerName)
+	if err != nil {
+		return err
+	}
+
+	prefix := deploymentParams.BlobPrefix()
+	blobs, err := client.List(ctx, prefix, false)
+	if err != nil {
+		return fmt.Errorf("unable to list the files of container %s (%s) with error: %w", *deploymentParams.ContainerName, *deploymentParams.StorageName, err)
+	}
+
+	deleted := 0
+	for _, blob := range blobs {
+		if err := client.Delete(ctx, blob.Name); err != nil {
+			return fmt.Errorf("unable to delete %s file in container %s with error: %w", blob.Name, *deploymentParams.ContainerName, err)
+		}
+		logger.V(1).Info("File deleted", "file", blob.Name)
+		observer.FileDeleted(blob.Name)
+		deleted++
+	}
+
+	logger.Info("Files deleted", "prefix", prefix, "files", deleted)
+	return nil
+}
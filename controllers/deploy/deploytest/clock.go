@@ -0,0 +1,40 @@
+package deploytest
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is a manual deploy.Clock. It only moves when advanced, waiting on it advances it at once so the retries of a
+// deployment do not slow the tests down.
+type Clock struct {
+	mutex sync.Mutex
+	now   time.Time
+}
+
+// NewClock returns a clock stopped at now
+func NewClock(now time.Time) *Clock {
+	return &Clock{now: now}
+}
+
+// Now returns the time of the clock
+func (clock *Clock) Now() time.Time {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	return clock.now
+}
+
+// Advance moves the clock forward
+func (clock *Clock) Advance(duration time.Duration) {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	clock.now = clock.now.Add(duration)
+}
+
+// After advances the clock by the duration and returns a channel already holding the new time
+func (clock *Clock) After(duration time.Duration) <-chan time.Time {
+	clock.Advance(duration)
+	after := make(chan time.Time, 1)
+	after <- clock.Now()
+	return after
+}
@@ -0,0 +1,283 @@
+// Package deploytest provides fakes of the Azure storage to test code built on the deploy package.
+package deploytest
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The well known Azurite account, any account name and key are accepted by the BlobServer
+const (
+	AccountName = "devstoreaccount1"
+	AccountKey  = "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw=="
+)
+
+// BlobServer serves a Storage with the subset of the Azure Storage REST API used by the deploy package: list,
+// get properties, get tags, download, upload and delete. It serves the storage accounts in path style like Azurite,
+// containers exist as soon as they are used, and the requests are not authenticated.
+type BlobServer struct {
+	*httptest.Server
+	*Storage
+
+	requests map[string]int
+}
+
+// NewBlobServer starts a BlobServer on an empty Storage, it must be closed once the test is done
+func NewBlobServer() *BlobServer {
+	server := &BlobServer{Storage: NewStorage(), requests: make(map[string]int)}
+	server.Server = httptest.NewServer(http.HandlerFunc(server.serveHTTP))
+	return server
+}
+
+// Requests returns the number of requests received per operation, e.g. "PUT blob" or "GET list"
+func (server *BlobServer) Requests() map[string]int {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	requests := make(map[string]int, len(server.requests))
+	for operation, count := range server.requests {
+		requests[operation] = count
+	}
+	return requests
+}
+
+func (server *BlobServer) serveHTTP(writer http.ResponseWriter, request *http.Request) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	// Path style: /account/container/blob/name
+	parts := strings.SplitN(strings.TrimPrefix(request.URL.Path, "/"), "/", 3)
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		writeError(writer, http.StatusBadRequest, "InvalidUri", "expecting /account/container[/blob]")
+		return
+	}
+	account, container := parts[0], parts[1]
+	query := request.URL.Query()
+
+	if len(parts) == 2 || parts[2] == "" {
+		server.requests[request.Method+" "+query.Get("comp")]++
+		if request.Method == http.MethodGet && query.Get("restype") == "container" && query.Get("comp") == "list" {
+			server.listBlobs(writer, account, container, query)
+			return
+		}
+		writeError(writer, http.StatusNotImplemented, "NotImplemented", "unsupported container operation")
+		return
+	}
+
+	name := parts[2]
+	key := blobKey(account, container, name)
+	operation := "blob"
+	if query.Get("comp") != "" {
+		operation = query.Get("comp")
+	}
+	server.requests[request.Method+" "+operation]++
+
+	if request.Method == http.MethodPut && operation == "blob" {
+		server.putBlob(writer, request, key)
+		return
+	}
+
+	blob, ok := server.blobs[key]
+	if !ok {
+		writeError(writer, http.StatusNotFound, "BlobNotFound", "The specified blob does not exist.")
+		return
+	}
+	switch {
+	case request.Method == http.MethodHead && operation == "blob":
+		writeProperties(writer, blob)
+		writer.WriteHeader(http.StatusOK)
+	case request.Method == http.MethodGet && operation == "blob":
+		writeProperties(writer, blob)
+		writer.Header().Set("Content-Length", strconv.Itoa(len(blob.Content)))
+		writer.WriteHeader(http.StatusOK)
+		_, _ = writer.Write(blob.Content)
+	case request.Method == http.MethodGet && operation == "tags":
+		writeXml(writer, http.StatusOK, tagsXml(blob.Tags))
+	case request.Method == http.MethodDelete && operation == "blob":
+		delete(server.blobs, key)
+		writer.WriteHeader(http.StatusAccepted)
+	default:
+		writeError(writer, http.StatusNotImplemented, "NotImplemented", "unsupported blob operation")
+	}
+}
+
+func (server *BlobServer) putBlob(writer http.ResponseWriter, request *http.Request, key string) {
+	content, err := io.ReadAll(request.Body)
+	if err != nil {
+		writeError(writer, http.StatusBadRequest, "InvalidInput", err.Error())
+		return
+	}
+
+	blob := &Blob{
+		Content:      content,
+		ContentType:  request.Header.Get("x-ms-blob-content-type"),
+		CacheControl: request.Header.Get("x-ms-blob-cache-control"),
+		Metadata:     make(map[string]string),
+	}
+	for header, values := range request.Header {
+		if strings.HasPrefix(strings.ToLower(header), "x-ms-meta-") {
+			blob.Metadata[strings.ToLower(strings.TrimPrefix(strings.ToLower(header), "x-ms-meta-"))] = values[0]
+		}
+	}
+	if tags := request.Header.Get("x-ms-tags"); tags != "" {
+		parsed, err := url.ParseQuery(tags)
+		if err != nil {
+			writeError(writer, http.StatusBadRequest, "InvalidTag", err.Error())
+			return
+		}
+		blob.Tags = make(map[string]string)
+		for tag, values := range parsed {
+			blob.Tags[tag] = values[0]
+		}
+	}
+	server.store(key, blob)
+
+	sum := md5.Sum(content)
+	writer.Header().Set("ETag", blob.ETag)
+	writer.Header().Set("Last-Modified", blob.LastModified.Format(http.TimeFormat))
+	writer.Header().Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+	writer.WriteHeader(http.StatusCreated)
+}
+
+func writeProperties(writer http.ResponseWriter, blob *Blob) {
+	sum := md5.Sum(blob.Content)
+	header := writer.Header()
+	header.Set("ETag", blob.ETag)
+	header.Set("Last-Modified", blob.LastModified.Format(http.TimeFormat))
+	header.Set("Content-Length", strconv.Itoa(len(blob.Content)))
+	header.Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+	header.Set("x-ms-blob-type", "BlockBlob")
+	if blob.ContentType != "" {
+		header.Set("Content-Type", blob.ContentType)
+	}
+	if blob.CacheControl != "" {
+		header.Set("Cache-Control", blob.CacheControl)
+	}
+	for key, value := range blob.Metadata {
+		header.Set("x-ms-meta-"+key, value)
+	}
+	if len(blob.Tags) > 0 {
+		header.Set("x-ms-tag-count", strconv.Itoa(len(blob.Tags)))
+	}
+}
+
+type tagXml struct {
+	Key   string `xml:"Key"`
+	Value string `xml:"Value"`
+}
+
+type tagsXmlDocument struct {
+	XMLName xml.Name `xml:"Tags"`
+	TagSet  []tagXml `xml:"TagSet>Tag"`
+}
+
+func tagsXml(tags map[string]string) tagsXmlDocument {
+	document := tagsXmlDocument{}
+	for key, value := range tags {
+		document.TagSet = append(document.TagSet, tagXml{Key: key, Value: value})
+	}
+	sort.Slice(document.TagSet, func(i, j int) bool { return document.TagSet[i].Key < document.TagSet[j].Key })
+	return document
+}
+
+type metadataXml map[string]string
+
+func (metadata metadataXml) MarshalXML(encoder *xml.Encoder, start xml.StartElement) error {
+	if err := encoder.EncodeToken(start); err != nil {
+		return err
+	}
+	for key, value := range metadata {
+		if err := encoder.EncodeElement(value, xml.StartElement{Name: xml.Name{Local: key}}); err != nil {
+			return err
+		}
+	}
+	return encoder.EncodeToken(start.End())
+}
+
+type blobPropertiesXml struct {
+	LastModified  string `xml:"Last-Modified"`
+	Etag          string `xml:"Etag"`
+	ContentLength int    `xml:"Content-Length"`
+	ContentType   string `xml:"Content-Type,omitempty"`
+	ContentMD5    string `xml:"Content-MD5"`
+	BlobType      string `xml:"BlobType"`
+}
+
+type blobXml struct {
+	Name       string            `xml:"Name"`
+	Properties blobPropertiesXml `xml:"Properties"`
+	Metadata   metadataXml       `xml:"Metadata,omitempty"`
+	Tags       *tagsXmlDocument  `xml:"Tags,omitempty"`
+}
+
+type listXmlDocument struct {
+	XMLName         xml.Name  `xml:"EnumerationResults"`
+	ServiceEndpoint string    `xml:"ServiceEndpoint,attr"`
+	ContainerName   string    `xml:"ContainerName,attr"`
+	Prefix          string    `xml:"Prefix"`
+	Blobs           []blobXml `xml:"Blobs>Blob"`
+	NextMarker      string    `xml:"NextMarker"`
+}
+
+// listBlobs returns every matching blob in a single page
+func (server *BlobServer) listBlobs(writer http.ResponseWriter, account string, container string, query url.Values) {
+	include := query.Get("include")
+	document := listXmlDocument{
+		ServiceEndpoint: server.URL + "/" + account + "/",
+		ContainerName:   container,
+		Prefix:          query.Get("prefix"),
+	}
+	for _, name := range server.list(account, container, query.Get("prefix")) {
+		blob := server.blobs[blobKey(account, container, name)]
+		sum := md5.Sum(blob.Content)
+		item := blobXml{
+			Name: name,
+			Properties: blobPropertiesXml{
+				LastModified:  blob.LastModified.Format(http.TimeFormat),
+				Etag:          blob.ETag,
+				ContentLength: len(blob.Content),
+				ContentType:   blob.ContentType,
+				ContentMD5:    base64.StdEncoding.EncodeToString(sum[:]),
+				BlobType:      "BlockBlob",
+			},
+		}
+		if strings.Contains(include, "metadata") && len(blob.Metadata) > 0 {
+			item.Metadata = blob.Metadata
+		}
+		if strings.Contains(include, "tags") && len(blob.Tags) > 0 {
+			tags := tagsXml(blob.Tags)
+			item.Tags = &tags
+		}
+		document.Blobs = append(document.Blobs, item)
+	}
+	writeXml(writer, http.StatusOK, document)
+}
+
+type errorXmlDocument struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string   `xml:"Code"`
+	Message string   `xml:"Message"`
+}
+
+func writeError(writer http.ResponseWriter, status int, code string, message string) {
+	writer.Header().Set("x-ms-error-code", code)
+	writeXml(writer, status, errorXmlDocument{Code: code, Message: message})
+}
+
+func writeXml(writer http.ResponseWriter, status int, document interface{}) {
+	body, err := xml.Marshal(document)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/xml")
+	writer.WriteHeader(status)
+	_, _ = writer.Write(append([]byte(xml.Header), body...))
+}
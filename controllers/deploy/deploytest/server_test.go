@@ -0,0 +1,116 @@
+package deploytest_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/morganleroi/deploy-website-k8s-operator/controllers/deploy"
+	"github.com/morganleroi/deploy-website-k8s-operator/controllers/deploy/deploytest"
+)
+
+func parameters(server *deploytest.BlobServer, version string, marker string) deploy.Parameters {
+	empty, storageName, accountKey := "", deploytest.AccountName, deploytest.AccountKey
+	containerName, packageContainerName := "$web", "packages"
+	fileNameToCheck, blobTagKey := "index.html", "version"
+	blobEndpoint, pathStyle := server.URL, true
+	return deploy.Parameters{
+		AzureCredential: &deploy.AzureCredential{TenantId: &empty, SpnId: &empty, SpnSecret: &empty, AccountKey: &accountKey},
+		StorageName:     &storageName,
+		ContainerName:   &containerName,
+		FileNameToCheck: &fileNameToCheck,
+		BlobTagKey:      &blobTagKey,
+		VersionToDeploy: &version,
+		Package:         &deploy.Package{StorageName: &storageName, ContainerName: &packageContainerName, AccountKey: &accountKey},
+		Marker:          &marker,
+		Endpoint:        &deploy.Endpoint{BlobEndpoint: &blobEndpoint, PathStyle: &pathStyle},
+	}
+}
+
+func TestBlobServerDeployment(t *testing.T) {
+	for _, marker := range []string{deploy.MarkerBlobTag, deploy.MarkerMetadata, deploy.MarkerFile, deploy.MarkerMetaTag} {
+		t.Run(marker, func(t *testing.T) {
+			server := deploytest.NewBlobServer()
+			defer server.Close()
+			ctx := context.Background()
+
+			for _, version := range []string{"v1", "v2"} {
+				server.PutBlob(deploytest.AccountName, "packages", version+".zip", deploytest.Package(map[string]string{
+					"index.html":  "<html><head><title>" + version + "</title></head></html>",
+					"app/main.js": "console.log('" + version + "')",
+				}), nil)
+			}
+
+			// First deployment in an empty container
+			if err := deploy.StartDeployment(ctx, parameters(server, "v1", marker), deploy.NoopObserver{}); err != nil {
+				t.Fatalf("first deployment failed: %v", err)
+			}
+			if err := deploy.VerifyDeployedVersion(ctx, parameters(server, "v1", marker), deploy.NoopObserver{}); err != nil {
+				t.Fatalf("v1 should be deployed: %v", err)
+			}
+
+			if err := deploy.StartDeployment(ctx, parameters(server, "v2", marker), deploy.NoopObserver{}); err != nil {
+				t.Fatalf("deployment failed: %v", err)
+			}
+			if err := deploy.VerifyDeployedVersion(ctx, parameters(server, "v2", marker), deploy.NoopObserver{}); err != nil {
+				t.Fatalf("v2 should be deployed: %v", err)
+			}
+			if _, ok := server.GetBlob(deploytest.AccountName, "$web", "app/main.js"); !ok {
+				t.Fatalf("app/main.js was not uploaded, got %v", server.BlobNames(deploytest.AccountName, "$web"))
+			}
+			index, _ := server.GetBlob(deploytest.AccountName, "$web", "index.html")
+			if marker == deploy.MarkerMetaTag && !strings.Contains(string(index.Content), `<meta name="version" content="v2">`) {
+				t.Fatalf("the meta tag was not injected: %s", index.Content)
+			}
+
+			uploads := server.Requests()["PUT blob"]
+			if err := deploy.StartDeployment(ctx, parameters(server, "v2", marker), deploy.NoopObserver{}); err != nil {
+				t.Fatalf("second deployment failed: %v", err)
+			}
+			if server.Requests()["PUT blob"] != uploads {
+				t.Fatalf("deploying the deployed version uploaded files")
+			}
+		})
+	}
+}
+
+func TestBlobServerMissingPackage(t *testing.T) {
+	server := deploytest.NewBlobServer()
+	defer server.Close()
+
+	server.PutBlob(deploytest.AccountName, "$web", "index.html", []byte("v1"), map[string]string{"version": "v1"})
+	err := deploy.StartDeployment(context.Background(), parameters(server, "v2", deploy.MarkerBlobTag), deploy.NoopObserver{})
+	if err == nil {
+		t.Fatalf("deploying a missing package should fail")
+	}
+}
+
+func TestBlobServerPlan(t *testing.T) {
+	server := deploytest.NewBlobServer()
+	defer server.Close()
+
+	server.PutBlob(deploytest.AccountName, "$web", "index.html", []byte("v1"), map[string]string{"version": "v1"})
+	server.PutBlob(deploytest.AccountName, "$web", "old.js", []byte("old"), nil)
+	server.PutBlob(deploytest.AccountName, "$web", "previews/pr-1/index.html", []byte("v1"), nil)
+	server.PutBlob(deploytest.AccountName, "packages", "v2.zip", deploytest.Package(map[string]string{"index.html": "v2", "new.js": "new"}), nil)
+
+	params := parameters(server, "v2", deploy.MarkerBlobTag)
+	params.ExcludedPrefixes = []string{"previews/pr-1/"}
+	plan, err := deploy.PlanDeployment(context.Background(), params, deploy.NoopObserver{})
+	if err != nil {
+		t.Fatalf("plan failed: %v", err)
+	}
+	if plan.DeployedVersion != "v1" || len(plan.Added) != 1 || len(plan.Changed) != 1 || len(plan.Stale) != 1 || plan.Stale[0] != "old.js" {
+		t.Fatalf("unexpected plan %+v", plan)
+	}
+	if len(server.BlobNames(deploytest.AccountName, "$web")) != 3 {
+		t.Fatalf("planning modified the container")
+	}
+
+	if err := deploy.StartDeployment(context.Background(), params, deploy.NoopObserver{}); err != nil {
+		t.Fatalf("deployment failed: %v", err)
+	}
+	if _, found := server.GetBlob(deploytest.AccountName, "$web", "old.js"); !found {
+		t.Fatalf("the stale files should be left in place")
+	}
+}
@@ -0,0 +1,309 @@
+package deploytest
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/morganleroi/deploy-website-k8s-operator/controllers/deploy"
+)
+
+// The operations of a deploy.Container, as counted by Storage.Operations and failed by Storage.Fail
+const (
+	OperationList          = "List"
+	OperationGetProperties = "GetProperties"
+	OperationGetTags       = "GetTags"
+	OperationDownload      = "Download"
+	OperationUpload        = "Upload"
+	OperationDelete        = "Delete"
+)
+
+// Blob is a blob held by a Storage
+type Blob struct {
+	Content      []byte
+	ContentType  string
+	CacheControl string
+	Metadata     map[string]string
+	Tags         map[string]string
+	ETag         string
+	LastModified time.Time
+}
+
+// Storage is an in-memory deploy.Storage, safe for concurrent use. Storage accounts and containers exist as soon as
+// they are used. Inject it in the deploy package with:
+//
+//	parameters.Dependencies = &deploy.Dependencies{Storage: storage.Factory()}
+type Storage struct {
+	mutex      sync.Mutex
+	blobs      map[string]*Blob
+	revision   int
+	operations map[string]int
+	failures   map[string]error
+}
+
+// NewStorage returns an empty Storage
+func NewStorage() *Storage {
+	return &Storage{blobs: make(map[string]*Blob), operations: make(map[string]int), failures: make(map[string]error)}
+}
+
+// Factory returns the deploy.StorageFactory returning this storage whatever the parameters
+func (storage *Storage) Factory() deploy.StorageFactory {
+	return func(deploy.Parameters) (deploy.Storage, error) {
+		return storage, nil
+	}
+}
+
+// Container returns a container of a storage account
+func (storage *Storage) Container(storageName string, containerName string) (deploy.Container, error) {
+	return &container{storage: storage, account: storageName, name: containerName}, nil
+}
+
+func blobKey(account string, container string, name string) string {
+	return account + "/" + container + "/" + name
+}
+
+// PutBlob stores a blob as if it was uploaded
+func (storage *Storage) PutBlob(account string, container string, name string, content []byte, tags map[string]string) {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	storage.store(blobKey(account, container, name), &Blob{Content: content, Tags: tags})
+}
+
+// GetBlob returns a copy of a stored blob
+func (storage *Storage) GetBlob(account string, container string, name string) (Blob, bool) {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	blob, ok := storage.blobs[blobKey(account, container, name)]
+	if !ok {
+		return Blob{}, false
+	}
+	return *blob, true
+}
+
+// DeleteBlob removes a stored blob
+func (storage *Storage) DeleteBlob(account string, container string, name string) {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	delete(storage.blobs, blobKey(account, container, name))
+}
+
+// BlobNames returns the sorted names of the blobs of a container
+func (storage *Storage) BlobNames(account string, container string) []string {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	return storage.list(account, container, "")
+}
+
+// Operations returns the number of calls per container operation, e.g. OperationUpload
+func (storage *Storage) Operations() map[string]int {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	operations := make(map[string]int, len(storage.operations))
+	for operation, count := range storage.operations {
+		operations[operation] = count
+	}
+	return operations
+}
+
+// Fail makes every call of the operation return err, until it is called again with a nil error.
+// Failing OperationGetTags with deploy.ErrTagsNotSupported emulates a storage whose tags can only be listed.
+func (storage *Storage) Fail(operation string, err error) {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+	if err == nil {
+		delete(storage.failures, operation)
+		return
+	}
+	storage.failures[operation] = err
+}
+
+// Package builds a zip package from file names and contents
+func Package(files map[string]string) []byte {
+	buffer := &bytes.Buffer{}
+	writer := zip.NewWriter(buffer)
+	for name, content := range files {
+		file, err := writer.Create(name)
+		if err != nil {
+			panic(err)
+		}
+		if _, err := file.Write([]byte(content)); err != nil {
+			panic(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		panic(err)
+	}
+	return buffer.Bytes()
+}
+
+func (storage *Storage) store(key string, blob *Blob) {
+	storage.revision++
+	sum := md5.Sum(blob.Content)
+	blob.ETag = fmt.Sprintf("\"0x%X%X\"", storage.revision, sum[:4])
+	blob.LastModified = time.Now().UTC().Truncate(time.Second)
+	storage.blobs[key] = blob
+}
+
+func (storage *Storage) list(account string, container string, prefix string) []string {
+	containerKey := blobKey(account, container, "")
+	var names []string
+	for key := range storage.blobs {
+		if strings.HasPrefix(key, containerKey+prefix) {
+			names = append(names, strings.TrimPrefix(key, containerKey))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// call counts an operation and returns its injected failure, if any. The storage must be locked.
+func (storage *Storage) call(operation string) error {
+	storage.operations[operation]++
+	return storage.failures[operation]
+}
+
+func properties(name string, blob *Blob) deploy.BlobProperties {
+	sum := md5.Sum(blob.Content)
+	metadata := make(map[string]string, len(blob.Metadata))
+	for key, value := range blob.Metadata {
+		metadata[key] = value
+	}
+	return deploy.BlobProperties{
+		Name:         name,
+		ETag:         blob.ETag,
+		ContentMD5:   sum[:],
+		ContentType:  blob.ContentType,
+		CacheControl: blob.CacheControl,
+		LastModified: blob.LastModified,
+		Metadata:     metadata,
+		TagCount:     len(blob.Tags),
+	}
+}
+
+func copyMap(values map[string]string) map[string]string {
+	if values == nil {
+		return nil
+	}
+	copied := make(map[string]string, len(values))
+	for key, value := range values {
+		copied[key] = value
+	}
+	return copied
+}
+
+// container is a deploy.Container of a Storage
+type container struct {
+	storage *Storage
+	account string
+	name    string
+}
+
+func (c *container) blob(name string) (*Blob, error) {
+	blob, ok := c.storage.blobs[blobKey(c.account, c.name, name)]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s in container %s (%s)", deploy.ErrBlobNotFound, name, c.name, c.account)
+	}
+	return blob, nil
+}
+
+func (c *container) List(_ context.Context, prefix string, withTags bool) ([]deploy.BlobProperties, error) {
+	c.storage.mutex.Lock()
+	defer c.storage.mutex.Unlock()
+	if err := c.storage.call(OperationList); err != nil {
+		return nil, err
+	}
+
+	var blobs []deploy.BlobProperties
+	for _, name := range c.storage.list(c.account, c.name, prefix) {
+		blob := c.storage.blobs[blobKey(c.account, c.name, name)]
+		item := properties(name, blob)
+		if withTags {
+			item.Tags = copyMap(blob.Tags)
+		}
+		blobs = append(blobs, item)
+	}
+	return blobs, nil
+}
+
+func (c *container) GetProperties(_ context.Context, name string) (deploy.BlobProperties, error) {
+	c.storage.mutex.Lock()
+	defer c.storage.mutex.Unlock()
+	if err := c.storage.call(OperationGetProperties); err != nil {
+		return deploy.BlobProperties{}, err
+	}
+
+	blob, err := c.blob(name)
+	if err != nil {
+		return deploy.BlobProperties{}, err
+	}
+	return properties(name, blob), nil
+}
+
+func (c *container) GetTags(_ context.Context, name string) (map[string]string, error) {
+	c.storage.mutex.Lock()
+	defer c.storage.mutex.Unlock()
+	if err := c.storage.call(OperationGetTags); err != nil {
+		return nil, err
+	}
+
+	blob, err := c.blob(name)
+	if err != nil {
+		return nil, err
+	}
+	tags := copyMap(blob.Tags)
+	if tags == nil {
+		tags = make(map[string]string)
+	}
+	return tags, nil
+}
+
+func (c *container) Download(_ context.Context, name string) ([]byte, error) {
+	c.storage.mutex.Lock()
+	defer c.storage.mutex.Unlock()
+	if err := c.storage.call(OperationDownload); err != nil {
+		return nil, err
+	}
+
+	blob, err := c.blob(name)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), blob.Content...), nil
+}
+
+func (c *container) Upload(_ context.Context, name string, content []byte, options deploy.UploadOptions) error {
+	c.storage.mutex.Lock()
+	defer c.storage.mutex.Unlock()
+	if err := c.storage.call(OperationUpload); err != nil {
+		return err
+	}
+
+	c.storage.store(blobKey(c.account, c.name, name), &Blob{
+		Content:      append([]byte(nil), content...),
+		ContentType:  options.ContentType,
+		CacheControl: options.CacheControl,
+		Metadata:     copyMap(options.Metadata),
+		Tags:         copyMap(options.Tags),
+	})
+	return nil
+}
+
+func (c *container) Delete(_ context.Context, name string) error {
+	c.storage.mutex.Lock()
+	defer c.storage.mutex.Unlock()
+	if err := c.storage.call(OperationDelete); err != nil {
+		return err
+	}
+
+	if _, err := c.blob(name); err != nil {
+		return err
+	}
+	delete(c.storage.blobs, blobKey(c.account, c.name, name))
+	return nil
+}
@@ -0,0 +1,87 @@
+package deploy
+
+import (
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"net/url"
+	"strings"
+)
+
+// Azure clouds the storage accounts can be located in
+const (
+	CloudPublic = "Public"
+	CloudChina  = "China"
+	CloudUSGov  = "USGov"
+)
+
+// cloudBlobDomains are the blob service domains of each cloud, the account name being the first label of the host
+var cloudBlobDomains = map[string]string{
+	CloudPublic: "blob.core.windows.net",
+	CloudChina:  "blob.core.chinacloudapi.cn",
+	CloudUSGov:  "blob.core.usgovcloudapi.net",
+}
+
+var cloudConfigurations = map[string]cloud.Configuration{
+	CloudPublic: cloud.AzurePublic,
+	CloudChina:  cloud.AzureChina,
+	CloudUSGov:  cloud.AzureGovernment,
+}
+
+// Endpoint locates the blob service of the storage accounts, the Azure public cloud when empty
+type Endpoint struct {
+	// Cloud is the Azure cloud of the storage accounts and of the SPN
+	Cloud *string
+	// BlobEndpoint replaces the blob service of the cloud, e.g. http://127.0.0.1:10000 for Azurite
+	BlobEndpoint *string
+	// PathStyle puts the account name in the path of the blob endpoint instead of its host, like Azurite does
+	PathStyle *bool
+}
+
+func (endpoint *Endpoint) cloud() string {
+	if endpoint == nil || endpoint.Cloud == nil || *endpoint.Cloud == "" {
+		return CloudPublic
+	}
+	return *endpoint.Cloud
+}
+
+func (endpoint *Endpoint) blobEndpoint() string {
+	if endpoint == nil || endpoint.BlobEndpoint == nil {
+		return ""
+	}
+	return strings.TrimSuffix(*endpoint.BlobEndpoint, "/")
+}
+
+func (endpoint *Endpoint) pathStyle() bool {
+	return endpoint != nil && endpoint.PathStyle != nil && *endpoint.PathStyle
+}
+
+// Validate checks the cloud is known and the custom blob endpoint is an absolute http(s) URL
+func (endpoint *Endpoint) Validate() error {
+	if _, ok := cloudBlobDomains[endpoint.cloud()]; !ok {
+		return fmt.Errorf("unknown cloud %q, expecting %s, %s or %s", endpoint.cloud(), CloudPublic, CloudChina, CloudUSGov)
+	}
+	if endpoint.blobEndpoint() == "" {
+		if endpoint.pathStyle() {
+			return fmt.Errorf("the path style requires a custom blob endpoint")
+		}
+		return nil
+	}
+	blobEndpoint, err := url.Parse(endpoint.blobEndpoint())
+	if err != nil || (blobEndpoint.Scheme != "http" && blobEndpoint.Scheme != "https") || blobEndpoint.Host == "" {
+		return fmt.Errorf("invalid blob endpoint %q, expecting an http(s) URL", endpoint.blobEndpoint())
+	}
+	return nil
+}
+
+// serviceUrl returns the URL of the blob service of a storage account, ending with a slash
+func (endpoint *Endpoint) serviceUrl(storageName string) string {
+	blobEndpoint := endpoint.blobEndpoint()
+	if blobEndpoint == "" {
+		return fmt.Sprintf("https://%s.%s/", storageName, cloudBlobDomains[endpoint.cloud()])
+	}
+	if endpoint.pathStyle() {
+		return fmt.Sprintf("%s/%s/", blobEndpoint, storageName)
+	}
+	scheme, host, _ := strings.Cut(blobEndpoint, "://")
+	return fmt.Sprintf("%s://%s.%s/", scheme, storageName, host)
+}
@@ -1,41 +1,70 @@
 package deploy
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"sigs.k8s.io/controller-runtime/pkg/log"
 )
 
-func StartDeployment(deploymentParams Parameters) error {
-	//deploymentParams := initializeParameters()
+// StartDeployment deploys the requested package version unless it is already the deployed one.
+// Progress is logged with the logger found in ctx.
+func StartDeployment(ctx context.Context, deploymentParams Parameters, observer Observer) error {
+	logger := log.FromContext(ctx)
 
-	credential, err := azidentity.NewClientSecretCredential(*deploymentParams.TenantId, *deploymentParams.SpnId, *deploymentParams.SpnSecret, nil)
+	storage, err := deploymentParams.storage()
 	if err != nil {
-		PrintHeaderToConsole("Deployment result")
-		return fmt.Errorf("unable to generate a secret credential %v", err)
+		return err
 	}
 
-	deployedPackageVersion, err := GetDeployedPackageVersion(deploymentParams, credential)
-
-	if err != nil {
-		PrintHeaderToConsole("Deployment result")
-		return fmt.Errorf("unable to get deployed package : %v", err)
+	deployedPackageVersion, err := GetDeployedPackageVersion(ctx, deploymentParams, storage, observer)
+	if errors.Is(err, ErrNothingDeployed) {
+		logger.Info("Nothing is deployed yet, first deployment")
+	} else if err != nil {
+		return fmt.Errorf("unable to get deployed package : %w", err)
 	}
 
 	if *deploymentParams.VersionToDeploy == deployedPackageVersion {
-		PrintHeaderToConsole("Deployment result")
-		fmt.Printf("The deployed package (%s) found in storage account %s is the same as the one you want to deploy (%s). Nothing to do. \n", deployedPackageVersion, *deploymentParams.StorageName, *deploymentParams.VersionToDeploy)
+		logger.Info("The deployed package is the same as the one to deploy. Nothing to do", "deployedVersion", deployedPackageVersion)
 		return nil
 	}
 
-	fmt.Printf("The deployed package (%s) found in storage account %s is different from the one you want to deploy (%s). Let's deploy it ! \n", deployedPackageVersion, *deploymentParams.StorageName, *deploymentParams.VersionToDeploy)
+	logger.Info("The deployed package is different from the one to deploy. Let's deploy it !", "deployedVersion", deployedPackageVersion)
 
-	err = Deploy(deploymentParams, credential)
+	err = Deploy(ctx, deploymentParams, storage, observer)
 	if err != nil {
-		PrintHeaderToConsole("Deployment result")
 		return err
 	}
 
-	PrintHeaderToConsole("Deployment result")
-	fmt.Println("Package deployed with success !")
+	logger.Info("Package deployed with success !")
 	return nil
 }
+
+// PlanDeployment computes what deploying the requested package version would change, without modifying the target
+func PlanDeployment(ctx context.Context, deploymentParams Parameters, observer Observer) (*Plan, error) {
+	storage, err := deploymentParams.storage()
+	if err != nil {
+		return nil, err
+	}
+
+	deployedPackageVersion, err := GetDeployedPackageVersion(ctx, deploymentParams, storage, observer)
+	if err != nil && !errors.Is(err, ErrNothingDeployed) {
+		return nil, fmt.Errorf("unable to get deployed package : %w", err)
+	}
+
+	plan, err := ComputePlan(ctx, deploymentParams, storage, observer)
+	if err != nil {
+		return nil, err
+	}
+	plan.DeployedVersion = deployedPackageVersion
+	return plan, nil
+}
+
+// DeployedVersion returns the deployed version, an error wrapping ErrNothingDeployed before the first deployment
+func DeployedVersion(ctx context.Context, deploymentParams Parameters, observer Observer) (string, error) {
+	storage, err := deploymentParams.storage()
+	if err != nil {
+		return "", err
+	}
+	return GetDeployedPackageVersion(ctx, deploymentParams, storage, observer)
+}
@@ -0,0 +1,228 @@
+package deploy_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/morganleroi/deploy-website-k8s-operator/controllers/deploy"
+	"github.com/morganleroi/deploy-website-k8s-operator/controllers/deploy/deploytest"
+)
+
+const storageName, containerName, packageContainerName = "site", "$web", "packages"
+
+func parameters(storage *deploytest.Storage, version string, marker string) deploy.Parameters {
+	empty, account, packageContainer, container := "", storageName, packageContainerName, containerName
+	fileNameToCheck, blobTagKey, markerFileName := "index.html", "version", deploy.DefaultMarkerFileName
+	return deploy.Parameters{
+		AzureCredential: &deploy.AzureCredential{TenantId: &empty, SpnId: &empty, SpnSecret: &empty},
+		StorageName:     &account,
+		ContainerName:   &container,
+		FileNameToCheck: &fileNameToCheck,
+		BlobTagKey:      &blobTagKey,
+		VersionToDeploy: &version,
+		Package:         &deploy.Package{StorageName: &account, ContainerName: &packageContainer},
+		Marker:          &marker,
+		MarkerFileName:  &markerFileName,
+		Dependencies:    &deploy.Dependencies{Storage: storage.Factory()},
+	}
+}
+
+func newStorage(versions ...string) *deploytest.Storage {
+	storage := deploytest.NewStorage()
+	for _, version := range versions {
+		storage.PutBlob(storageName, packageContainerName, version+".zip", deploytest.Package(map[string]string{
+			"index.html":  "<html><head><title>" + version + "</title></head></html>",
+			"app/main.js": "console.log('" + version + "')",
+		}), nil)
+	}
+	return storage
+}
+
+func TestStartDeployment(t *testing.T) {
+	for _, marker := range []string{deploy.MarkerBlobTag, deploy.MarkerMetadata, deploy.MarkerFile, deploy.MarkerMetaTag} {
+		t.Run(marker, func(t *testing.T) {
+			storage := newStorage("v1", "v2")
+			ctx := context.Background()
+
+			for _, version := range []string{"v1", "v2"} {
+				if err := deploy.StartDeployment(ctx, parameters(storage, version, marker), deploy.NoopObserver{}); err != nil {
+					t.Fatalf("deployment of %s failed: %v", version, err)
+				}
+				if err := deploy.VerifyDeployedVersion(ctx, parameters(storage, version, marker), deploy.NoopObserver{}); err != nil {
+					t.Fatalf("%s should be deployed: %v", version, err)
+				}
+			}
+
+			uploads := storage.Operations()[deploytest.OperationUpload]
+			if err := deploy.StartDeployment(ctx, parameters(storage, "v2", marker), deploy.NoopObserver{}); err != nil {
+				t.Fatalf("redeployment failed: %v", err)
+			}
+			if storage.Operations()[deploytest.OperationUpload] != uploads {
+				t.Fatalf("deploying the deployed version uploaded files")
+			}
+		})
+	}
+}
+
+func TestStartDeploymentUploadFailure(t *testing.T) {
+	storage := newStorage("v1")
+	storage.Fail(deploytest.OperationUpload, errors.New("connection reset"))
+
+	err := deploy.StartDeployment(context.Background(), parameters(storage, "v1", deploy.MarkerFile), deploy.NoopObserver{})
+	if err == nil {
+		t.Fatalf("the upload failure should be returned")
+	}
+	if _, ok := storage.GetBlob(storageName, containerName, deploy.DefaultMarkerFileName); ok {
+		t.Fatalf("the marker file must not be written when the package is partially deployed")
+	}
+}
+
+func TestStartTargetsDeployment(t *testing.T) {
+	storage := deploytest.NewStorage()
+	for _, version := range []string{"v1", "v2"} {
+		storage.PutBlob(storageName, packageContainerName, version+".zip", deploytest.Package(map[string]string{
+			"shell/index.html":    "<html><head><title>shell " + version + "</title></head></html>",
+			"checkout/index.html": "<html><head><title>checkout " + version + "</title></head></html>",
+		}), nil)
+	}
+	targets := func(version string, directories ...string) []deploy.Parameters {
+		var targets []deploy.Parameters
+		for _, directory := range directories {
+			target := parameters(storage, version, deploy.MarkerFile)
+			container, packagePath := directory, directory+"/"
+			target.ContainerName, target.PackagePath = &container, &packagePath
+			targets = append(targets, target)
+		}
+		return targets
+	}
+	ctx := context.Background()
+
+	results, err := deploy.StartTargetsDeployment(ctx, targets("v1", "shell", "checkout"), deploy.NoopObserver{})
+	if err != nil || len(results) != 2 || !results[1].Deployed {
+		t.Fatalf("both targets should be deployed, got %+v, %v", results, err)
+	}
+	index, _ := storage.GetBlob(storageName, "checkout", "index.html")
+	if !strings.Contains(string(index.Content), "checkout v1") {
+		t.Fatalf("the checkout directory should be deployed at the root of its container, got %q", index.Content)
+	}
+	if storage.Operations()[deploytest.OperationDownload] != 1 {
+		t.Fatalf("the package should be downloaded once, got %v", storage.Operations())
+	}
+
+	results, err = deploy.StartTargetsDeployment(ctx, targets("v2", "shell", "missing", "checkout"), deploy.NoopObserver{})
+	if err == nil || len(results) != 2 || results[1].Err == nil || results[0].DeployedVersion != "v2" {
+		t.Fatalf("the deployment should stop at the missing directory, got %+v, %v", results, err)
+	}
+	if err := deploy.VerifyDeployedVersion(ctx, targets("v1", "checkout")[0], deploy.NoopObserver{}); err != nil {
+		t.Fatalf("the targets following the failure should not be deployed: %v", err)
+	}
+}
+
+func TestGetDeployedPackageVersion(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("nothing deployed", func(t *testing.T) {
+		storage := newStorage()
+		params := parameters(storage, "v1", deploy.MarkerBlobTag)
+		_, err := deploy.GetDeployedPackageVersion(ctx, params, storage, deploy.NoopObserver{})
+		if !errors.Is(err, deploy.ErrNothingDeployed) {
+			t.Fatalf("expecting ErrNothingDeployed, got %v", err)
+		}
+	})
+
+	t.Run("tags listed when they cannot be read", func(t *testing.T) {
+		storage := newStorage()
+		storage.PutBlob(storageName, containerName, "index.html", []byte("v1"), map[string]string{"version": "v1"})
+		storage.Fail(deploytest.OperationGetTags, deploy.ErrTagsNotSupported)
+
+		version, err := deploy.GetDeployedPackageVersion(ctx, parameters(storage, "v1", deploy.MarkerBlobTag), storage, deploy.NoopObserver{})
+		if err != nil || version != "v1" {
+			t.Fatalf("expecting v1, got %q, %v", version, err)
+		}
+		if storage.Operations()[deploytest.OperationList] != 1 {
+			t.Fatalf("the tags should have been listed, got %v", storage.Operations())
+		}
+	})
+
+	t.Run("cached by ETag", func(t *testing.T) {
+		storage := newStorage()
+		storage.PutBlob(storageName, containerName, deploy.DefaultMarkerFileName, []byte(`{"version": "v1"}`), nil)
+		params := parameters(storage, "v1", deploy.MarkerFile)
+		params.VersionCache = deploy.NewVersionCache()
+
+		for i := 0; i < 2; i++ {
+			if version, err := deploy.GetDeployedPackageVersion(ctx, params, storage, deploy.NoopObserver{}); err != nil || version != "v1" {
+				t.Fatalf("expecting v1, got %q, %v", version, err)
+			}
+		}
+		if storage.Operations()[deploytest.OperationDownload] != 1 {
+			t.Fatalf("the unchanged marker should be downloaded once, got %v", storage.Operations())
+		}
+
+		storage.PutBlob(storageName, containerName, deploy.DefaultMarkerFileName, []byte(`{"version": "v2"}`), nil)
+		if version, err := deploy.GetDeployedPackageVersion(ctx, params, storage, deploy.NoopObserver{}); err != nil || version != "v2" {
+			t.Fatalf("expecting v2 once the marker changed, got %q, %v", version, err)
+		}
+	})
+}
+
+func TestVerifyRetries(t *testing.T) {
+	attempts := 0
+	site := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, _ *http.Request) {
+		attempts++
+		if attempts < 3 {
+			writer.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer site.Close()
+
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := deploytest.NewClock(start)
+	verification := deploy.Verification{
+		BaseUrl:       site.URL,
+		Checks:        []deploy.Check{{Path: "/"}},
+		Retries:       3,
+		RetryInterval: time.Minute,
+		Timeout:       time.Second,
+		Clock:         clock,
+	}
+
+	if err := deploy.Verify(context.Background(), verification, "v1", deploy.NoopObserver{}); err != nil {
+		t.Fatalf("the verification should pass on the third attempt: %v", err)
+	}
+	if waited := clock.Now().Sub(start); waited != 2*time.Minute {
+		t.Fatalf("expecting two retry intervals, waited %s", waited)
+	}
+}
+
+// stepDurations records the duration of each finished step
+type stepDurations struct {
+	deploy.NoopObserver
+	durations map[deploy.Step]time.Duration
+}
+
+func (o stepDurations) StepFinished(step deploy.Step, duration time.Duration, _ error) {
+	o.durations[step] = duration
+}
+
+func TestCallSwitchHookClock(t *testing.T) {
+	clock := deploytest.NewClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	hook := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		clock.Advance(3 * time.Second)
+	}))
+	defer hook.Close()
+
+	observer := stepDurations{durations: map[deploy.Step]time.Duration{}}
+	params := deploy.Parameters{Dependencies: &deploy.Dependencies{Clock: clock}}
+	if err := deploy.CallSwitchHook(context.Background(), params, hook.URL, deploy.SwitchHook{Slot: "green"}, observer); err != nil {
+		t.Fatalf("the switch hook failed: %v", err)
+	}
+	if duration := observer.durations[deploy.StepSwitch]; duration != 3*time.Second {
+		t.Fatalf("expecting the switch step to last 3s on the injected clock, got %s", duration)
+	}
+}
@@ -0,0 +1,248 @@
+package deploy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+)
+
+// Marker strategies, the way the deployed version is recorded on the target container
+const (
+	// MarkerBlobTag tags every deployed file with the version, the version is read on the file to check
+	MarkerBlobTag = "BlobTag"
+	// MarkerMetadata sets the version in the metadata of every deployed file, the version is read on the file to check
+	MarkerMetadata = "Metadata"
+	// MarkerFile writes the version in a dedicated file once every other file is deployed
+	MarkerFile = "File"
+	// MarkerMetaTag injects the version in a <meta> tag of the file to check, which must be an HTML file
+	MarkerMetaTag = "MetaTag"
+)
+
+// DefaultMarkerFileName is the file written by the File marker strategy
+const DefaultMarkerFileName = "version.json"
+
+// VersionMarker records the deployed version on the target container and reads it back
+type VersionMarker interface {
+	// MarkerBlob returns the name of the blob carrying the version
+	MarkerBlob() string
+	// ReadVersion returns the version carried by the marker blob, whose properties were just read
+	ReadVersion(ctx context.Context, container Container, properties BlobProperties) (string, error)
+	// MarkUpload marks a deployed blob with the version, through its upload options or its content
+	MarkUpload(blobName string, content []byte, options *UploadOptions) []byte
+	// MarkerFiles returns the files recording the version, uploaded once every other file is deployed
+	MarkerFiles() map[string][]byte
+}
+
+// NewVersionMarker returns the marker selected by the parameters, blob tags by default.
+// The key is the blob tag key, the metadata key or the meta tag name depending on the strategy.
+func NewVersionMarker(parameters Parameters) (VersionMarker, error) {
+	fileNameToCheck := parameters.BlobPrefix() + *parameters.FileNameToCheck
+	key := *parameters.BlobTagKey
+	version := ""
+	if parameters.VersionToDeploy != nil {
+		version = *parameters.VersionToDeploy
+	}
+
+	switch parameters.MarkerStrategy() {
+	case MarkerBlobTag:
+		return blobTagMarker{fileName: fileNameToCheck, key: key, version: version}, nil
+	case MarkerMetadata:
+		return metadataMarker{fileName: fileNameToCheck, key: key, version: version}, nil
+	case MarkerFile:
+		return fileMarker{fileName: parameters.BlobPrefix() + parameters.MarkerFile(), key: key, version: version}, nil
+	case MarkerMetaTag:
+		return metaTagMarker{fileName: fileNameToCheck, name: key, version: version}, nil
+	default:
+		return nil, fmt.Errorf("unknown version marker %q", parameters.MarkerStrategy())
+	}
+}
+
+// blobTagMarker is the historical marker, it requires the blob tag permissions and is not available with a hierarchical namespace
+type blobTagMarker struct {
+	fileName string
+	key      string
+	version  string
+}
+
+func (m blobTagMarker) MarkerBlob() string {
+	return m.fileName
+}
+
+func (m blobTagMarker) ReadVersion(ctx context.Context, container Container, properties BlobProperties) (string, error) {
+	if properties.TagCount == 0 {
+		return "", fmt.Errorf("unable to find %s tag in %s file", m.key, m.fileName)
+	}
+
+	tags, err := container.GetTags(ctx, m.fileName)
+	if errors.Is(err, ErrTagsNotSupported) {
+		return m.listVersion(ctx, container)
+	}
+	if err != nil {
+		return "", fmt.Errorf("unable to get %s file tags with error: %w", m.fileName, err)
+	}
+	return m.findTag(tags)
+}
+
+// listVersion reads the tag by listing the blobs starting with the file name, the fallback when the tags cannot be read directly
+func (m blobTagMarker) listVersion(ctx context.Context, container Container) (string, error) {
+	blobs, err := container.List(ctx, m.fileName, true)
+	if err != nil {
+		return "", err
+	}
+	for _, blob := range blobs {
+		if blob.Name == m.fileName {
+			return m.findTag(blob.Tags)
+		}
+	}
+	return "", fmt.Errorf("unable to find %s file", m.fileName)
+}
+
+func (m blobTagMarker) findTag(tags map[string]string) (string, error) {
+	if version, ok := tags[m.key]; ok {
+		return version, nil
+	}
+	return "", fmt.Errorf("unable to find %s tag in %s file", m.key, m.fileName)
+}
+
+func (m blobTagMarker) MarkUpload(_ string, content []byte, options *UploadOptions) []byte {
+	options.Tags = map[string]string{m.key: m.version}
+	return content
+}
+
+func (m blobTagMarker) MarkerFiles() map[string][]byte {
+	return nil
+}
+
+// metadataMarker works on any storage account, metadata only requiring the blob data permissions
+type metadataMarker struct {
+	fileName string
+	key      string
+	version  string
+}
+
+func (m metadataMarker) MarkerBlob() string {
+	return m.fileName
+}
+
+func (m metadataMarker) ReadVersion(_ context.Context, _ Container, properties BlobProperties) (string, error) {
+	// Metadata keys are case insensitive, they come back as canonical HTTP header names
+	for key, value := range properties.Metadata {
+		if strings.EqualFold(key, m.key) {
+			return value, nil
+		}
+	}
+	return "", fmt.Errorf("unable to find %s metadata in %s file", m.key, m.fileName)
+}
+
+func (m metadataMarker) MarkUpload(_ string, content []byte, options *UploadOptions) []byte {
+	options.Metadata = map[string]string{m.key: m.version}
+	return content
+}
+
+func (m metadataMarker) MarkerFiles() map[string][]byte {
+	return nil
+}
+
+// fileMarker writes a version.json file, {"<key>": "<version>"}, or a plain text file for other extensions
+type fileMarker struct {
+	fileName string
+	key      string
+	version  string
+}
+
+func (m fileMarker) MarkerBlob() string {
+	return m.fileName
+}
+
+func (m fileMarker) ReadVersion(ctx context.Context, container Container, _ BlobProperties) (string, error) {
+	content, err := downloadBlob(ctx, container, m.fileName)
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasSuffix(m.fileName, ".json") {
+		return strings.TrimSpace(string(content)), nil
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(content, &fields); err != nil {
+		return "", fmt.Errorf("unable to read %s file with error: %w", m.fileName, err)
+	}
+	version, ok := fields[m.key].(string)
+	if !ok {
+		return "", fmt.Errorf("unable to find %s field in %s file", m.key, m.fileName)
+	}
+	return version, nil
+}
+
+func (m fileMarker) MarkUpload(_ string, content []byte, _ *UploadOptions) []byte {
+	return content
+}
+
+func (m fileMarker) MarkerFiles() map[string][]byte {
+	if !strings.HasSuffix(m.fileName, ".json") {
+		return map[string][]byte{m.fileName: []byte(m.version + "\n")}
+	}
+	content, _ := json.Marshal(map[string]string{m.key: m.version})
+	return map[string][]byte{m.fileName: content}
+}
+
+// metaTagMarker injects <meta name="<name>" content="<version>"> in the head of the file to check
+type metaTagMarker struct {
+	fileName string
+	name     string
+	version  string
+}
+
+func (m metaTagMarker) pattern() *regexp.Regexp {
+	return regexp.MustCompile(`<meta\s+name="` + regexp.QuoteMeta(m.name) + `"\s+content="([^"]*)"\s*/?>`)
+}
+
+func (m metaTagMarker) MarkerBlob() string {
+	return m.fileName
+}
+
+func (m metaTagMarker) ReadVersion(ctx context.Context, container Container, _ BlobProperties) (string, error) {
+	content, err := downloadBlob(ctx, container, m.fileName)
+	if err != nil {
+		return "", err
+	}
+	match := m.pattern().FindSubmatch(content)
+	if match == nil {
+		return "", fmt.Errorf("unable to find %s meta tag in %s file", m.name, m.fileName)
+	}
+	return html.UnescapeString(string(match[1])), nil
+}
+
+func (m metaTagMarker) MarkUpload(blobName string, content []byte, _ *UploadOptions) []byte {
+	if blobName != m.fileName {
+		return content
+	}
+
+	metaTag := []byte(fmt.Sprintf(`<meta name="%s" content="%s">`, html.EscapeString(m.name), html.EscapeString(m.version)))
+	if m.pattern().Match(content) {
+		return m.pattern().ReplaceAllLiteral(content, metaTag)
+	}
+	head := regexp.MustCompile(`(?i)<head(\s[^>]*)?>`).FindIndex(content)
+	if head == nil {
+		// Without head the tag is added at the beginning, browsers still accept it
+		return append(metaTag, content...)
+	}
+	return bytes.Join([][]byte{content[:head[1]], metaTag, content[head[1]:]}, nil)
+}
+
+func (m metaTagMarker) MarkerFiles() map[string][]byte {
+	return nil
+}
+
+func downloadBlob(ctx context.Context, container Container, fileName string) ([]byte, error) {
+	content, err := container.Download(ctx, fileName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to download %s file with error: %w", fileName, err)
+	}
+	return content, nil
+}
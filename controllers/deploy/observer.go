@@ -0,0 +1,74 @@
+package deploy
+
+import "time"
+
+// Step identifies a stage of the deployment pipeline
+type Step string
+
+const (
+	StepVersionCheck Step = "VersionCheck"
+	StepDownload     Step = "Download"
+	StepExtract      Step = "Extract"
+	StepUpload       Step = "Upload"
+	StepPlan         Step = "Plan"
+	StepResolve      Step = "Resolve"
+	StepSwitch       Step = "Switch"
+	StepCleanup      Step = "Cleanup"
+	StepVerify       Step = "Verify"
+)
+
+// Observer is notified of the deployment progress: steps start and end, and the data transferred.
+// It allows callers to report progress (events, metrics...) without the deploy package knowing about them.
+type Observer interface {
+	StepStarted(step Step)
+	StepFinished(step Step, duration time.Duration, err error)
+	PackageDownloaded(size int64)
+	FileUploaded(fileName string, size int64)
+	FileDeleted(fileName string)
+}
+
+// NoopObserver ignores every notification, it can be embedded to implement only part of Observer
+type NoopObserver struct{}
+
+func (NoopObserver) StepStarted(Step) {}
+
+func (NoopObserver) StepFinished(Step, time.Duration, error) {}
+
+func (NoopObserver) PackageDownloaded(int64) {}
+
+func (NoopObserver) FileUploaded(string, int64) {}
+
+func (NoopObserver) FileDeleted(string) {}
+
+// Observers forwards every notification to each of its observers
+type Observers []Observer
+
+func (o Observers) StepStarted(step Step) {
+	for _, observer := range o {
+		observer.StepStarted(step)
+	}
+}
+
+func (o Observers) StepFinished(step Step, duration time.Duration, err error) {
+	for _, observer := range o {
+		observer.StepFinished(step, duration, err)
+	}
+}
+
+func (o Observers) PackageDownloaded(size int64) {
+	for _, observer := range o {
+		observer.PackageDownloaded(size)
+	}
+}
+
+func (o Observers) FileUploaded(fileName string, size int64) {
+	for _, observer := range o {
+		observer.FileUploaded(fileName, size)
+	}
+}
+
+func (o Observers) FileDeleted(fileName string) {
+	for _, observer := range o {
+		observer.FileDeleted(fileName)
+	}
+}
@@ -1,14 +1,13 @@
 package deploy
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"sigs.k8s.io/controller-runtime/pkg/log"
 	"strings"
-	"time"
 )
 
-const AzureBlobDomain string = "blob.core.windows.net"
-
 type Parameters struct {
 	*AzureCredential
 	StorageName     *string
@@ -17,34 +16,66 @@ type Parameters struct {
 	BlobTagKey      *string
 	VersionToDeploy *string
 	Package         *Package
+	// Prefix is prepended to the name of the deployed files, to deploy in a folder of the container
+	Prefix *string
+	// PackagePath is the directory of the package deployed to the container, the whole package when empty
+	PackagePath *string
+	// Marker is the strategy recording the deployed version, see NewVersionMarker
+	Marker *string
+	// MarkerFileName is the file written by the File marker strategy
+	MarkerFileName *string
+	// ExcludedPrefixes are the folders of the container the plan ignores, e.g. the previews deployed next to the site
+	ExcludedPrefixes []string
+	// VersionCache avoids reading the version of an unchanged marker blob again, optional
+	VersionCache *VersionCache
+	// Endpoint locates the storage accounts, the Azure public cloud when nil
+	Endpoint *Endpoint
+	// Dependencies replaces the storage, credentials or clock of the deployment, the defaults when nil
+	Dependencies *Dependencies
 }
 
 type AzureCredential struct {
 	TenantId  *string
 	SpnId     *string
 	SpnSecret *string
+	// AccountKey authenticates on the target storage account instead of the SPN, required on Azurite
+	AccountKey *string
 }
 
 type Package struct {
 	StorageName   *string
 	ContainerName *string
+	// AccountKey authenticates on the package storage account instead of the SPN
+	AccountKey *string
 }
 
-func InitParameters() Parameters {
+// InitParameters defines the deployment parameters as flags of the flag set, they are filled once the flags are parsed
+func InitParameters(flags *flag.FlagSet) Parameters {
 	return Parameters{
 		AzureCredential: &AzureCredential{
-			TenantId:  flag.String("tenantId", "", "Azure Subscription TenantId"),
-			SpnId:     flag.String("spnId", "", "Azure SPN Id (Could be found here https://paas-front-end.labpaas.prd.euw.gbis.sg-azure.com/my_spn)"),
-			SpnSecret: flag.String("spnSecret", "", "Azure SPN Secret (Could be found here https://paas-front-end.labpaas.prd.euw.gbis.sg-azure.com/my_spn"),
+			TenantId:   flags.String("tenantId", "", "Azure Subscription TenantId"),
+			SpnId:      flags.String("spnId", "", "Azure SPN Id (Could be found here https://paas-front-end.labpaas.prd.euw.gbis.sg-azure.com/my_spn)"),
+			SpnSecret:  flags.String("spnSecret", "", "Azure SPN Secret (Could be found here https://paas-front-end.labpaas.prd.euw.gbis.sg-azure.com/my_spn"),
+			AccountKey: flags.String("accountKey", "", "Azure storage account key, used instead of the SPN on the storage account where is located the App"),
 		},
-		StorageName:     flag.String("storageName", "", "Azure storage account name where is located the App"),
-		ContainerName:   flag.String("containerName", "$web", "Azure storage account container name where is located the file to check"),
-		FileNameToCheck: flag.String("fileNameToCheck", "index.html", "The file inside the storage account we need to check app version"),
-		BlobTagKey:      flag.String("blobTagKey", "version", "The blob tag key on the file where is located the version"),
-		VersionToDeploy: flag.String("versionToDeploy", "", "Version to deploy"),
+		StorageName:     flags.String("storageName", "", "Azure storage account name where is located the App"),
+		ContainerName:   flags.String("containerName", "$web", "Azure storage account container name where is located the file to check"),
+		FileNameToCheck: flags.String("fileNameToCheck", "index.html", "The file inside the storage account we need to check app version"),
+		BlobTagKey:      flags.String("blobTagKey", "version", "The blob tag key on the file where is located the version"),
+		VersionToDeploy: flags.String("versionToDeploy", "", "Version to deploy"),
+		Marker:          flags.String("marker", MarkerBlobTag, "How the deployed version is recorded: BlobTag, Metadata, File or MetaTag"),
+		MarkerFileName:  flags.String("markerFileName", DefaultMarkerFileName, "The file where the version is written with the File marker"),
+		Prefix:          flags.String("prefix", "", "Folder of the container where the files are deployed, e.g. previews/pr-42/"),
+		PackagePath:     flags.String("packagePath", "", "Directory of the package to deploy, e.g. checkout/, the whole package when empty"),
 		Package: &Package{
-			StorageName:   flag.String("packageStorageName", "", "Azure storage account name where is located the package to deploy"),
-			ContainerName: flag.String("packageContainerName", "packages", "Azure storage account container name where is located the package to deploy"),
+			StorageName:   flags.String("packageStorageName", "", "Azure storage account name where is located the package to deploy"),
+			ContainerName: flags.String("packageContainerName", "packages", "Azure storage account container name where is located the package to deploy"),
+			AccountKey:    flags.String("packageAccountKey", "", "Azure storage account key, used instead of the SPN on the storage account where is located the package to deploy"),
+		},
+		Endpoint: &Endpoint{
+			Cloud:        flags.String("cloud", CloudPublic, "Azure cloud of the storage accounts: Public, China or USGov"),
+			BlobEndpoint: flags.String("blobEndpoint", "", "Custom blob service endpoint, e.g. http://127.0.0.1:10000 for Azurite"),
+			PathStyle:    flags.Bool("pathStyle", false, "Put the storage account name in the path of the custom blob endpoint instead of its host"),
 		},
 	}
 }
@@ -52,10 +83,10 @@ func InitParameters() Parameters {
 func (parameters Parameters) String() string {
 	var builder strings.Builder
 
-	PrintHeaderToConsole("Script parameters")
 	builder.WriteString(fmt.Sprintf("TenantId: %s \n", *parameters.TenantId))
 	builder.WriteString(fmt.Sprintf("SpnId: %s \n", *parameters.SpnId))
 	builder.WriteString(fmt.Sprintf("BlobTagKey: %s \n", *parameters.BlobTagKey))
+	builder.WriteString(fmt.Sprintf("Marker: %s \n", parameters.MarkerStrategy()))
 	builder.WriteString(fmt.Sprintf("FileNameToCheck: %v \n", *parameters.FileNameToCheck))
 	builder.WriteString(fmt.Sprintf("ContainerName: %s \n", *parameters.ContainerName))
 	builder.WriteString(fmt.Sprintf("SpnSecret: %s \n", Obfuscate(*parameters.SpnSecret)))
@@ -67,25 +98,93 @@ func (parameters Parameters) String() string {
 }
 
 func (parameters Parameters) PackageUrl() string {
-	return fmt.Sprintf("https://%s.%s/%s/", *parameters.Package.StorageName, AzureBlobDomain, *parameters.Package.ContainerName)
+	return fmt.Sprintf("%s%s/", parameters.Endpoint.serviceUrl(*parameters.Package.StorageName), *parameters.Package.ContainerName)
 }
 
 func (parameters Parameters) StorageUrl() string {
-	return fmt.Sprintf("https://%s.%s/%s/", *parameters.StorageName, AzureBlobDomain, *parameters.ContainerName)
+	return fmt.Sprintf("%s%s/", parameters.Endpoint.serviceUrl(*parameters.StorageName), *parameters.ContainerName)
 }
 
-func (parameters Parameters) Validate() (bool, []string) {
-	var parametersError []string
-	if *parameters.TenantId == "" {
-		parametersError = append(parametersError, "TenantId")
+// BlobPrefix returns the prefix of the deployed files, empty when deploying at the root of the container
+func (parameters Parameters) BlobPrefix() string {
+	if parameters.Prefix == nil {
+		return ""
+	}
+	return *parameters.Prefix
+}
+
+// PackageDirectory returns the directory of the package to deploy with its trailing slash, empty for the whole package
+func (parameters Parameters) PackageDirectory() string {
+	if parameters.PackagePath == nil {
+		return ""
+	}
+	directory := strings.Trim(*parameters.PackagePath, "/")
+	if directory == "" {
+		return ""
+	}
+	return directory + "/"
+}
+
+// MarkerStrategy returns the version marker strategy, blob tags when not set
+func (parameters Parameters) MarkerStrategy() string {
+	if parameters.Marker == nil || *parameters.Marker == "" {
+		return MarkerBlobTag
+	}
+	return *parameters.Marker
+}
+
+// MarkerFile returns the file written by the File marker strategy
+func (parameters Parameters) MarkerFile() string {
+	if parameters.MarkerFileName == nil || *parameters.MarkerFileName == "" {
+		return DefaultMarkerFileName
 	}
+	return *parameters.MarkerFileName
+}
 
-	if *parameters.SpnId == "" {
-		parametersError = append(parametersError, "SpnId")
+// accountKeys returns the account key of each storage account authenticated with its key instead of the SPN
+func (parameters Parameters) accountKeys() map[string]string {
+	accountKeys := make(map[string]string)
+	if parameters.AccountKey != nil && *parameters.AccountKey != "" {
+		accountKeys[*parameters.StorageName] = *parameters.AccountKey
+	}
+	if parameters.Package.AccountKey != nil && *parameters.Package.AccountKey != "" {
+		accountKeys[*parameters.Package.StorageName] = *parameters.Package.AccountKey
 	}
+	return accountKeys
+}
+
+// storage creates the storage of the deployment with the injected factory, the Azure one by default
+func (parameters Parameters) storage() (Storage, error) {
+	return parameters.Dependencies.storage()(parameters)
+}
+
+// clock returns the injected clock, the system one by default
+func (parameters Parameters) clock() Clock {
+	return parameters.Dependencies.clock()
+}
 
-	if *parameters.SpnSecret == "" {
-		parametersError = append(parametersError, "SpnSecret")
+// requiresSpn tells whether a storage account is accessed with the SPN
+func (parameters Parameters) requiresSpn() bool {
+	accountKeys := parameters.accountKeys()
+	_, targetKey := accountKeys[*parameters.StorageName]
+	_, packageKey := accountKeys[*parameters.Package.StorageName]
+	return !targetKey || !packageKey
+}
+
+func (parameters Parameters) Validate() (bool, []string) {
+	var parametersError []string
+	if parameters.requiresSpn() {
+		if *parameters.TenantId == "" {
+			parametersError = append(parametersError, "TenantId")
+		}
+
+		if *parameters.SpnId == "" {
+			parametersError = append(parametersError, "SpnId")
+		}
+
+		if *parameters.SpnSecret == "" {
+			parametersError = append(parametersError, "SpnSecret")
+		}
 	}
 
 	if *parameters.StorageName == "" {
@@ -122,10 +221,34 @@ func (parameters Parameters) Validate() (bool, []string) {
 	return false, parametersError
 }
 
-func declareNewStep(stepName string) func() {
-	start := time.Now()
-	PrintHeaderToConsole(stepName)
-	return func() {
-		fmt.Printf("End %s after %s\n", stepName, time.Since(start))
+// declareNewStep logs, traces and notifies the observer that a step starts.
+// It returns the context to use within the step and the function ending it.
+// err must point to the error returned by the step so the observer knows whether it failed.
+func declareNewStep(ctx context.Context, clock Clock, observer Observer, step Step, err *error) (context.Context, func()) {
+	ctx, span := tracer.Start(ctx, string(step))
+	logger := log.FromContext(ctx).WithValues("step", step)
+	start := clock.Now()
+	logger.Info("Step started")
+	observer.StepStarted(step)
+	return ctx, func() {
+		duration := clock.Now().Sub(start)
+		if *err != nil {
+			// The error is returned to the caller which is in charge of reporting it
+			logger.Info("Step failed", "duration", duration, "error", (*err).Error())
+		} else {
+			logger.Info("Step finished", "duration", duration)
+		}
+		observer.StepFinished(step, duration, *err)
+		endSpan(span, *err)
+	}
+}
+
+// excluded tells whether the blob belongs to one of the excluded folders of the container
+func (deploymentParams Parameters) excluded(blobName string) bool {
+	for _, prefix := range deploymentParams.ExcludedPrefixes {
+		if strings.HasPrefix(blobName, prefix) {
+			return true
+		}
 	}
+	return false
 }
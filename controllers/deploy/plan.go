@@ -0,0 +1,132 @@
+package deploy
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sort"
+	"strings"
+)
+
+// Plan describes what a deployment would change in the target container
+type Plan struct {
+	DeployedVersion string `json:"deployedVersion"`
+	// Added lists the package files missing from the target
+	Added []string `json:"added"`
+	// Changed lists the package files whose content differs from the target one
+	Changed []string `json:"changed"`
+	// Stale lists the target files missing from the package, the deployment leaves them in place
+	Stale []string `json:"stale"`
+	// Unchanged is the number of package files identical on the target
+	Unchanged int `json:"unchanged"`
+}
+
+// ComputePlan downloads and validates the package then compares its files with the target container, without uploading anything
+func ComputePlan(ctx context.Context, deploymentParameters Parameters, storage Storage, observer Observer) (*Plan, error) {
+	downloadedData, err := downloadPackage(ctx, deploymentParameters, storage, observer)
+	if err != nil {
+		return nil, err
+	}
+
+	extractedFiles, err := extractPackage(ctx, deploymentParameters, downloadedData, observer)
+	if err != nil {
+		return nil, err
+	}
+
+	extractedFiles, err = packageFiles(deploymentParameters, extractedFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	return planPackage(ctx, deploymentParameters, extractedFiles, storage, observer)
+}
+
+func planPackage(ctx context.Context, deploymentParameters Parameters, extractedFiles map[string]*bytes.Buffer, storage Storage, observer Observer) (plan *Plan, err error) {
+	ctx, endStep := declareNewStep(ctx, deploymentParameters.clock(), observer, StepPlan, &err)
+	defer endStep()
+	logger := log.FromContext(ctx).WithValues("step", StepPlan)
+
+	if _, ok := extractedFiles[*deploymentParameters.FileNameToCheck]; !ok {
+		return nil, fmt.Errorf("invalid package: %s file is missing, the deployed version could not be checked afterwards", *deploymentParameters.FileNameToCheck)
+	}
+
+	marker, err := NewVersionMarker(deploymentParameters)
+	if err != nil {
+		return nil, err
+	}
+
+	targetFiles, err := listTargetFiles(ctx, deploymentParameters, storage)
+	if err != nil {
+		return nil, err
+	}
+
+	plan = diffFiles(markFiles(deploymentParameters, marker, extractedFiles), targetFiles)
+	logger.Info("Plan computed", "added", len(plan.Added), "changed", len(plan.Changed), "stale", len(plan.Stale), "unchanged", plan.Unchanged)
+	return plan, nil
+}
+
+// listTargetFiles returns the MD5 of each file of the target container (or of its prefix), nil when the storage did not compute it
+func listTargetFiles(ctx context.Context, deploymentParameters Parameters, storage Storage) (map[string][]byte, error) {
+	client, err := storage.Container(*deploymentParameters.StorageName, *deploymentParameters.ContainerName)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := deploymentParameters.BlobPrefix()
+	blobs, err := client.List(ctx, prefix, false)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the files of container %s (%s) with error: %w", *deploymentParameters.ContainerName, *deploymentParameters.StorageName, err)
+	}
+
+	targetFiles := make(map[string][]byte, len(blobs))
+	for _, blob := range blobs {
+		if deploymentParameters.excluded(blob.Name) {
+			continue
+		}
+		targetFiles[strings.TrimPrefix(blob.Name, prefix)] = blob.ContentMD5
+	}
+	return targetFiles, nil
+}
+
+// markFiles returns the files as they would be uploaded, marked with the version and completed by the marker files
+func markFiles(deploymentParameters Parameters, marker VersionMarker, extractedFiles map[string]*bytes.Buffer) map[string]*bytes.Buffer {
+	markedFiles := make(map[string]*bytes.Buffer, len(extractedFiles))
+	for fileName, content := range extractedFiles {
+		markedFiles[fileName] = bytes.NewBuffer(marker.MarkUpload(deploymentParameters.BlobPrefix()+fileName, content.Bytes(), &UploadOptions{}))
+	}
+	for fileName, content := range marker.MarkerFiles() {
+		markedFiles[strings.TrimPrefix(fileName, deploymentParameters.BlobPrefix())] = bytes.NewBuffer(content)
+	}
+	return markedFiles
+}
+
+// diffFiles compares the package files with the target ones.
+// A target file without MD5 (uploaded in several blocks) is always considered as changed.
+func diffFiles(extractedFiles map[string]*bytes.Buffer, targetFiles map[string][]byte) *Plan {
+	plan := &Plan{}
+	for fileName, content := range extractedFiles {
+		targetMD5, exists := targetFiles[fileName]
+		if !exists {
+			plan.Added = append(plan.Added, fileName)
+			continue
+		}
+		packageMD5 := md5.Sum(content.Bytes())
+		if bytes.Equal(packageMD5[:], targetMD5) {
+			plan.Unchanged++
+		} else {
+			plan.Changed = append(plan.Changed, fileName)
+		}
+	}
+	for fileName := range targetFiles {
+		if _, exists := extractedFiles[fileName]; !exists {
+			plan.Stale = append(plan.Stale, fileName)
+		}
+	}
+
+	sort.Strings(plan.Added)
+	sort.Strings(plan.Changed)
+	sort.Strings(plan.Stale)
+	return plan
+}
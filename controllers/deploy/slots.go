@@ -0,0 +1,126 @@
+package deploy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// pointerTemplate redirects the visitors of the container root to the active slot
+const pointerTemplate = `<!DOCTYPE html>
+<html>
+<head>
+<meta http-equiv="refresh" content="0; url=/%[1]s">
+<link rel="canonical" href="/%[1]s">
+</head>
+<body><a href="/%[1]s">Redirecting</a></body>
+</html>
+`
+
+// SwitchHook is the body posted to the switch hook when the active slot changes
+type SwitchHook struct {
+	Namespace     string `json:"namespace"`
+	Webapp        string `json:"webapp"`
+	Slot          string `json:"slot"`
+	Version       string `json:"version"`
+	StorageName   string `json:"storageName"`
+	ContainerName string `json:"containerName"`
+	Prefix        string `json:"prefix,omitempty"`
+}
+
+// DeployVersion deploys the requested package version, whatever the version currently deployed
+func DeployVersion(ctx context.Context, deploymentParams Parameters, observer Observer) error {
+	storage, err := deploymentParams.storage()
+	if err != nil {
+		return err
+	}
+	return Deploy(ctx, deploymentParams, storage, observer)
+}
+
+// VerifyDeployedVersion checks the requested package version is the deployed one
+func VerifyDeployedVersion(ctx context.Context, deploymentParams Parameters, observer Observer) error {
+	storage, err := deploymentParams.storage()
+	if err != nil {
+		return err
+	}
+
+	deployedPackageVersion, err := GetDeployedPackageVersion(ctx, deploymentParams, storage, observer)
+	if err != nil {
+		return fmt.Errorf("unable to get deployed package : %w", err)
+	}
+	if deployedPackageVersion != *deploymentParams.VersionToDeploy {
+		return fmt.Errorf("version %s is deployed instead of %s", deployedPackageVersion, *deploymentParams.VersionToDeploy)
+	}
+	return nil
+}
+
+// WritePointer makes the file to check at the root of the container redirect to the slot prefix.
+// The pointer is marked with the version like a deployed file, so the live version can still be checked at the root.
+func WritePointer(ctx context.Context, deploymentParams Parameters, slotPrefix string, observer Observer) (err error) {
+	ctx, endStep := declareNewStep(ctx, deploymentParams.clock(), observer, StepSwitch, &err)
+	defer endStep()
+
+	storage, err := deploymentParams.storage()
+	if err != nil {
+		return err
+	}
+
+	marker, err := NewVersionMarker(deploymentParams)
+	if err != nil {
+		return err
+	}
+
+	client, err := storage.Container(*deploymentParams.StorageName, *deploymentParams.ContainerName)
+	if err != nil {
+		return err
+	}
+
+	pointerUrl := deploymentParams.StorageUrl() + *deploymentParams.FileNameToCheck
+	options := UploadOptions{ContentType: "text/html", CacheControl: "no-cache"}
+	content := fmt.Sprintf(pointerTemplate, html.EscapeString(slotPrefix+*deploymentParams.FileNameToCheck))
+	err = client.Upload(ctx, *deploymentParams.FileNameToCheck, marker.MarkUpload(*deploymentParams.FileNameToCheck, []byte(content), &options), options)
+	if err != nil {
+		return fmt.Errorf("unable to write pointer %s with error: %w", pointerUrl, err)
+	}
+
+	for fileName, markerContent := range marker.MarkerFiles() {
+		if err = client.Upload(ctx, fileName, markerContent, UploadOptions{CacheControl: "no-cache"}); err != nil {
+			return fmt.Errorf("unable to write version marker %s with error: %w", fileName, err)
+		}
+	}
+
+	log.FromContext(ctx).Info("Pointer switched", "pointer", pointerUrl, "slotPrefix", slotPrefix)
+	return nil
+}
+
+// CallSwitchHook posts the new active slot to a user provided hook, in charge of routing the traffic (e.g. updating a CDN origin)
+func CallSwitchHook(ctx context.Context, deploymentParams Parameters, hookUrl string, hook SwitchHook, observer Observer) (err error) {
+	ctx, endStep := declareNewStep(ctx, deploymentParams.clock(), observer, StepSwitch, &err)
+	defer endStep()
+
+	body, err := json.Marshal(hook)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, hookUrl, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("invalid switch hook %s: %w", hookUrl, err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("unable to call switch hook %s with error: %w", hookUrl, err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("switch hook %s answered %s", hookUrl, response.Status)
+	}
+
+	log.FromContext(ctx).Info("Switch hook called", "hook", hookUrl, "slot", hook.Slot)
+	return nil
+}
@@ -0,0 +1,110 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"time"
+)
+
+// ErrBlobNotFound is returned by a Container when the requested blob does not exist
+var ErrBlobNotFound = errors.New("blob not found")
+
+// ErrTagsNotSupported is returned by Container.GetTags when the storage cannot read the tags of a single blob,
+// they may still be listed
+var ErrTagsNotSupported = errors.New("blob tags not supported")
+
+// BlobProperties are the properties of a blob, as read or listed
+type BlobProperties struct {
+	Name         string
+	ETag         string
+	ContentMD5   []byte
+	ContentType  string
+	CacheControl string
+	LastModified time.Time
+	Metadata     map[string]string
+	// Tags are only filled by Container.List when asked for
+	Tags     map[string]string
+	TagCount int
+}
+
+// UploadOptions are the headers, metadata and tags of an uploaded blob
+type UploadOptions struct {
+	ContentType  string
+	CacheControl string
+	Metadata     map[string]string
+	Tags         map[string]string
+}
+
+// Container reads and writes the blobs of a storage container
+type Container interface {
+	// List returns the blobs whose name starts with the prefix, with their tags when withTags is true
+	List(ctx context.Context, prefix string, withTags bool) ([]BlobProperties, error)
+	// GetProperties returns the properties of a blob, an error wrapping ErrBlobNotFound when it does not exist
+	GetProperties(ctx context.Context, name string) (BlobProperties, error)
+	// GetTags returns the tags of a blob, an error wrapping ErrTagsNotSupported when they can only be listed
+	GetTags(ctx context.Context, name string) (map[string]string, error)
+	Download(ctx context.Context, name string) ([]byte, error)
+	Upload(ctx context.Context, name string, content []byte, options UploadOptions) error
+	Delete(ctx context.Context, name string) error
+}
+
+// Storage gives access to the containers of the package and target storage accounts
+type Storage interface {
+	Container(storageName string, containerName string) (Container, error)
+}
+
+// StorageFactory creates the storage used by a deployment
+type StorageFactory func(parameters Parameters) (Storage, error)
+
+// CredentialProvider creates the token credential of the storage accounts accessed without account key
+type CredentialProvider func(parameters Parameters) (azcore.TokenCredential, error)
+
+// Clock tells the time and waits, steps are timed and verifications retried with it
+type Clock interface {
+	Now() time.Time
+	After(duration time.Duration) <-chan time.Time
+}
+
+// Dependencies are the services a deployment relies on, replaced in tests.
+// A nil field uses the default: the Azure blob service, the SPN of the parameters and the system clock.
+type Dependencies struct {
+	Storage     StorageFactory
+	Credentials CredentialProvider
+	Clock       Clock
+}
+
+// systemClock is the default Clock
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(duration time.Duration) <-chan time.Time {
+	return time.After(duration)
+}
+
+// SystemClock is the clock used when none is injected
+var SystemClock Clock = systemClock{}
+
+func (dependencies *Dependencies) storage() StorageFactory {
+	if dependencies == nil || dependencies.Storage == nil {
+		return NewAzureStorage
+	}
+	return dependencies.Storage
+}
+
+func (dependencies *Dependencies) credentials() CredentialProvider {
+	if dependencies == nil || dependencies.Credentials == nil {
+		return NewSpnCredential
+	}
+	return dependencies.Credentials
+}
+
+func (dependencies *Dependencies) clock() Clock {
+	if dependencies == nil || dependencies.Clock == nil {
+		return SystemClock
+	}
+	return dependencies.Clock
+}
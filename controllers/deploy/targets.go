@@ -0,0 +1,112 @@
+package deploy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// TargetResult reports the deployment of one of the targets of a package
+type TargetResult struct {
+	// DeployedVersion is the version found or deployed on the target, empty when unknown
+	DeployedVersion string
+	// Deployed tells whether the package was uploaded, false when the target already held the version
+	Deployed bool
+	// Err is the error which stopped the deployments, nil when the target is up to date
+	Err error
+}
+
+// StartTargetsDeployment deploys the package version to each target, in order, stopping at the first failure.
+// The targets share the package, which is downloaded and extracted once, only if a target does not hold the version.
+// It returns the result of each target reached, the last one holding the error.
+func StartTargetsDeployment(ctx context.Context, targets []Parameters, observer Observer) ([]TargetResult, error) {
+	logger := log.FromContext(ctx)
+	results := make([]TargetResult, 0, len(targets))
+	fail := func(result TargetResult, err error) ([]TargetResult, error) {
+		result.Err = err
+		return append(results, result), err
+	}
+
+	var extractedFiles map[string]*bytes.Buffer
+	for _, target := range targets {
+		result := TargetResult{}
+		storage, err := target.storage()
+		if err != nil {
+			return fail(result, err)
+		}
+
+		result.DeployedVersion, err = GetDeployedPackageVersion(ctx, target, storage, observer)
+		if err != nil && !errors.Is(err, ErrNothingDeployed) {
+			return fail(result, fmt.Errorf("unable to get deployed package : %w", err))
+		}
+		if result.DeployedVersion == *target.VersionToDeploy {
+			logger.Info("The target already holds the version. Nothing to do", "container", *target.ContainerName, "prefix", target.BlobPrefix())
+			results = append(results, result)
+			continue
+		}
+
+		if extractedFiles == nil {
+			downloadedData, err := downloadPackage(ctx, target, storage, observer)
+			if err != nil {
+				return fail(result, err)
+			}
+			if extractedFiles, err = extractPackage(ctx, target, downloadedData, observer); err != nil {
+				return fail(result, err)
+			}
+		}
+
+		files, err := packageFiles(target, extractedFiles)
+		if err != nil {
+			return fail(result, err)
+		}
+		logger.Info("Deploying the target", "container", *target.ContainerName, "prefix", target.BlobPrefix(), "directory", target.PackageDirectory(), "deployedVersion", result.DeployedVersion)
+		if err := deployPackage(ctx, target, files, storage, observer); err != nil {
+			return fail(result, err)
+		}
+		result.DeployedVersion = *target.VersionToDeploy
+		result.Deployed = true
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// PlanTargets computes the plan of each target, the package being downloaded and extracted once
+func PlanTargets(ctx context.Context, targets []Parameters, observer Observer) ([]*Plan, error) {
+	plans := make([]*Plan, 0, len(targets))
+	var extractedFiles map[string]*bytes.Buffer
+	for _, target := range targets {
+		storage, err := target.storage()
+		if err != nil {
+			return nil, err
+		}
+
+		deployedVersion, err := GetDeployedPackageVersion(ctx, target, storage, observer)
+		if err != nil && !errors.Is(err, ErrNothingDeployed) {
+			return nil, fmt.Errorf("unable to get deployed package : %w", err)
+		}
+
+		if extractedFiles == nil {
+			downloadedData, err := downloadPackage(ctx, target, storage, observer)
+			if err != nil {
+				return nil, err
+			}
+			if extractedFiles, err = extractPackage(ctx, target, downloadedData, observer); err != nil {
+				return nil, err
+			}
+		}
+
+		files, err := packageFiles(target, extractedFiles)
+		if err != nil {
+			return nil, err
+		}
+		plan, err := planPackage(ctx, target, files, storage, observer)
+		if err != nil {
+			return nil, err
+		}
+		plan.DeployedVersion = deployedVersion
+		plans = append(plans, plan)
+	}
+	return plans, nil
+}
@@ -0,0 +1,31 @@
+package deploy
+
+import (
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+)
+
+// uploadBatchSize is the number of files uploaded within a single traced batch
+const uploadBatchSize = 50
+
+var tracer = otel.Tracer("github.com/morganleroi/deploy-website-k8s-operator/controllers/deploy")
+
+// httpClient traces every call made by the Azure SDK as a child span of the current step.
+// It does nothing until a tracer provider is registered.
+var httpClient = &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)}
+
+func clientOptions() *azblob.ClientOptions {
+	return &azblob.ClientOptions{Transport: httpClient}
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,138 @@
+package deploy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"strings"
+	"time"
+)
+
+// maxVerifiedBodySize bounds the part of the responses read by the checks
+const maxVerifiedBodySize = 1 << 20
+
+// Check is a request made on the site once deployed
+type Check struct {
+	// Path is appended to the verification base URL
+	Path string
+	// ExpectedStatus is the expected response status code, 200 when zero
+	ExpectedStatus int
+	// Contains is a text the response body must contain, if any
+	Contains string
+	// VersionField is the field of the JSON response body which must hold the deployed version, if any
+	VersionField string
+}
+
+// Verification describes the checks of a deployed version and how they are retried
+type Verification struct {
+	BaseUrl       string
+	Checks        []Check
+	Retries       int
+	RetryInterval time.Duration
+	Timeout       time.Duration
+	// Clock waits between the retries, the system clock when nil
+	Clock Clock
+}
+
+// VerificationError reports a deployed version failing its checks
+type VerificationError struct {
+	Version string
+	Err     error
+}
+
+func (e *VerificationError) Error() string {
+	return fmt.Sprintf("verification of version %s failed: %v", e.Version, e.Err)
+}
+
+func (e *VerificationError) Unwrap() error {
+	return e.Err
+}
+
+// Verify runs the checks against the deployed site, retrying them all until they pass or the retries are exhausted
+func Verify(ctx context.Context, verification Verification, version string, observer Observer) (err error) {
+	clock := verification.Clock
+	if clock == nil {
+		clock = SystemClock
+	}
+	ctx, endStep := declareNewStep(ctx, clock, observer, StepVerify, &err)
+	defer endStep()
+	logger := log.FromContext(ctx).WithValues("step", StepVerify)
+
+	for attempt := 0; ; attempt++ {
+		err = runChecks(ctx, verification, version)
+		if err == nil {
+			logger.Info("Deployment verified", "checks", len(verification.Checks), "attempts", attempt+1)
+			return nil
+		}
+		if attempt >= verification.Retries {
+			return &VerificationError{Version: version, Err: err}
+		}
+
+		logger.Info("Verification failed, retrying", "attempt", attempt+1, "error", err.Error())
+		select {
+		case <-clock.After(verification.RetryInterval):
+		case <-ctx.Done():
+			return &VerificationError{Version: version, Err: fmt.Errorf("%v, interrupted: %w", err, ctx.Err())}
+		}
+	}
+}
+
+func runChecks(ctx context.Context, verification Verification, version string) error {
+	for _, check := range verification.Checks {
+		checkUrl := strings.TrimSuffix(verification.BaseUrl, "/") + "/" + strings.TrimPrefix(check.Path, "/")
+		if err := runCheck(ctx, checkUrl, check, version, verification.Timeout); err != nil {
+			return fmt.Errorf("%s: %w", checkUrl, err)
+		}
+	}
+	return nil
+}
+
+func runCheck(ctx context.Context, checkUrl string, check Check, version string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, checkUrl, nil)
+	if err != nil {
+		return err
+	}
+	// The site may be behind a CDN, the check must see the deployed files
+	request.Header.Set("Cache-Control", "no-cache")
+
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	expectedStatus := check.ExpectedStatus
+	if expectedStatus == 0 {
+		expectedStatus = http.StatusOK
+	}
+	if response.StatusCode != expectedStatus {
+		return fmt.Errorf("status %d instead of %d", response.StatusCode, expectedStatus)
+	}
+
+	if check.Contains == "" && check.VersionField == "" {
+		return nil
+	}
+	body, err := io.ReadAll(io.LimitReader(response.Body, maxVerifiedBodySize))
+	if err != nil {
+		return err
+	}
+	if check.Contains != "" && !strings.Contains(string(body), check.Contains) {
+		return fmt.Errorf("body does not contain %q", check.Contains)
+	}
+	if check.VersionField != "" {
+		var fields map[string]interface{}
+		if err := json.Unmarshal(body, &fields); err != nil {
+			return fmt.Errorf("body is not a JSON object: %w", err)
+		}
+		if deployedVersion := fmt.Sprint(fields[check.VersionField]); deployedVersion != version {
+			return fmt.Errorf("%s is %q instead of %q", check.VersionField, deployedVersion, version)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package deploy
+
+import (
+	"sync"
+)
+
+// VersionCache remembers the version read on each marker blob with the ETag of the blob,
+// so the version of an unchanged blob is not read again between two reconciliations.
+// Setting the blob index tags does not change the ETag of a blob: a version tag rewritten outside of the operator,
+// without uploading the blob again, is not seen until the blob changes or the operator restarts.
+// A nil cache is valid and caches nothing.
+type VersionCache struct {
+	mutex    sync.Mutex
+	versions map[string]cachedVersion
+}
+
+type cachedVersion struct {
+	etag    string
+	version string
+}
+
+func NewVersionCache() *VersionCache {
+	return &VersionCache{versions: make(map[string]cachedVersion)}
+}
+
+func (cache *VersionCache) lookup(key string, etag string) (string, bool) {
+	if cache == nil || etag == "" {
+		return "", false
+	}
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	cached, ok := cache.versions[key]
+	if !ok || cached.etag != etag {
+		return "", false
+	}
+	return cached.version, true
+}
+
+func (cache *VersionCache) store(key string, etag string, version string) {
+	if cache == nil || etag == "" {
+		return
+	}
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.versions[key] = cachedVersion{etag: etag, version: version}
+}
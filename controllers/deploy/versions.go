@@ -0,0 +1,112 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"github.com/Masterminds/semver/v3"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"strings"
+)
+
+// packageExtension is the extension of the packages stored in the package container, the version being the blob name
+const packageExtension = ".zip"
+
+// ListPackageVersions returns the versions available in the package container
+func ListPackageVersions(ctx context.Context, deploymentParams Parameters, observer Observer) (versions []string, err error) {
+	ctx, endStep := declareNewStep(ctx, deploymentParams.clock(), observer, StepResolve, &err)
+	defer endStep()
+
+	storage, err := deploymentParams.storage()
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := storage.Container(*deploymentParams.Package.StorageName, *deploymentParams.Package.ContainerName)
+	if err != nil {
+		return nil, err
+	}
+
+	blobs, err := client.List(ctx, "", false)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the packages of container %s (%s) with error: %w", *deploymentParams.Package.ContainerName, *deploymentParams.Package.StorageName, err)
+	}
+	for _, blob := range blobs {
+		if strings.HasSuffix(blob.Name, packageExtension) {
+			versions = append(versions, strings.TrimSuffix(blob.Name, packageExtension))
+		}
+	}
+
+	log.FromContext(ctx).V(1).Info("Packages listed", "versions", len(versions))
+	return versions, nil
+}
+
+// LatestVersion picks the highest version of the channel satisfying the semver constraint.
+// The channel is the suffix of the versions to consider (e.g. ".master" for v1.2.3.master), the other ones are ignored
+// like the versions which are not semver. An empty constraint accepts any release version.
+func LatestVersion(versions []string, constraint string, channel string) (string, error) {
+	var constraints *semver.Constraints
+	if constraint != "" {
+		var err error
+		constraints, err = semver.NewConstraint(constraint)
+		if err != nil {
+			return "", fmt.Errorf("invalid version constraint %q: %w", constraint, err)
+		}
+	}
+
+	latestVersion := ""
+	var latest *semver.Version
+	for _, version := range versions {
+		if !strings.HasSuffix(version, channel) {
+			continue
+		}
+		parsed, err := semver.NewVersion(strings.TrimSuffix(version, channel))
+		if err != nil {
+			continue
+		}
+		if constraints != nil && !constraints.Check(parsed) {
+			continue
+		}
+		if constraints == nil && parsed.Prerelease() != "" {
+			continue
+		}
+		if latest == nil || parsed.GreaterThan(latest) {
+			latest = parsed
+			latestVersion = version
+		}
+	}
+
+	if latest == nil {
+		return "", fmt.Errorf("no package version matches constraint %q on channel %q", constraint, channel)
+	}
+	return latestVersion, nil
+}
+
+// PreviousVersion picks the highest version of the channel lower than the current one, the version to roll back to
+// when no history is available. Like LatestVersion, the pre-releases and the versions which are not semver are ignored.
+func PreviousVersion(versions []string, current string, channel string) (string, error) {
+	currentVersion, err := semver.NewVersion(strings.TrimSuffix(current, channel))
+	if err != nil {
+		return "", fmt.Errorf("the deployed version %q is not a semver version of channel %q: %w", current, channel, err)
+	}
+
+	previousVersion := ""
+	var previous *semver.Version
+	for _, version := range versions {
+		if !strings.HasSuffix(version, channel) {
+			continue
+		}
+		parsed, err := semver.NewVersion(strings.TrimSuffix(version, channel))
+		if err != nil || parsed.Prerelease() != "" || !parsed.LessThan(currentVersion) {
+			continue
+		}
+		if previous == nil || parsed.GreaterThan(previous) {
+			previous = parsed
+			previousVersion = version
+		}
+	}
+
+	if previous == nil {
+		return "", fmt.Errorf("no package version of channel %q before %s", channel, current)
+	}
+	return previousVersion, nil
+}
@@ -0,0 +1,95 @@
+package deploy_test
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	"github.com/morganleroi/deploy-website-k8s-operator/controllers/deploy"
+	"github.com/morganleroi/deploy-website-k8s-operator/controllers/deploy/deploytest"
+)
+
+var packageVersions = []string{
+	"v1.0.0.master", "v1.2.0.master", "v1.2.3.master", "v2.0.0-rc.1.master", "v1.3.0.develop",
+	"latest.master", "v1.4.master", "1.2.4.master", "v0.9.0",
+}
+
+func TestLatestVersion(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		constraint string
+		channel    string
+		expected   string
+		// invalid tells whether no version is expected
+		invalid bool
+	}{
+		{"any release of the channel", "", ".master", "v1.4.master", false},
+		{"constraint", "~1.2", ".master", "1.2.4.master", false},
+		{"constraint excluding the channel latest", "<1.2.0", ".master", "v1.0.0.master", false},
+		{"pre-release allowed by the constraint", ">=2.0.0-0", ".master", "v2.0.0-rc.1.master", false},
+		{"other channel", "", ".develop", "v1.3.0.develop", false},
+		{"no channel", "<1.0.0", "", "v0.9.0", false},
+		{"no version matching the constraint", ">=3", ".master", "", true},
+		{"unknown channel", "", ".feature", "", true},
+		{"invalid constraint", "not a constraint", ".master", "", true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			version, err := deploy.LatestVersion(packageVersions, test.constraint, test.channel)
+			if test.invalid {
+				if err == nil {
+					t.Fatalf("expecting an error, got %s", version)
+				}
+				return
+			}
+			if err != nil || version != test.expected {
+				t.Fatalf("expecting %s, got %s (%v)", test.expected, version, err)
+			}
+		})
+	}
+}
+
+func TestPreviousVersion(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		current  string
+		channel  string
+		expected string
+		invalid  bool
+	}{
+		{"previous release", "v1.2.3.master", ".master", "v1.2.0.master", false},
+		{"pre-releases are ignored", "v2.0.0.master", ".master", "v1.4.master", false},
+		{"current version missing from the packages", "v1.2.1.master", ".master", "v1.2.0.master", false},
+		{"no previous version", "v1.0.0.master", ".master", "", true},
+		{"deployed version not semver", "latest.master", ".master", "", true},
+		{"deployed version of another channel", "v1.3.0.develop", ".master", "", true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			version, err := deploy.PreviousVersion(packageVersions, test.current, test.channel)
+			if test.invalid {
+				if err == nil {
+					t.Fatalf("expecting an error, got %s", version)
+				}
+				return
+			}
+			if err != nil || version != test.expected {
+				t.Fatalf("expecting %s, got %s (%v)", test.expected, version, err)
+			}
+		})
+	}
+}
+
+func TestListPackageVersions(t *testing.T) {
+	storage := deploytest.NewStorage()
+	storage.PutBlob(storageName, packageContainerName, "v1.0.0.master.zip", nil, nil)
+	storage.PutBlob(storageName, packageContainerName, "v1.1.0.master.zip", nil, nil)
+	storage.PutBlob(storageName, packageContainerName, "README.md", nil, nil)
+
+	versions, err := deploy.ListPackageVersions(context.Background(), parameters(storage, "", deploy.MarkerBlobTag), deploy.NoopObserver{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(versions)
+	if len(versions) != 2 || versions[0] != "v1.0.0.master" || versions[1] != "v1.1.0.master" {
+		t.Fatalf("expecting the versions of the packages only, got %v", versions)
+	}
+}
@@ -0,0 +1,25 @@
+package controllers
+
+import (
+	webappv1alpha1 "github.com/morganleroi/deploy-website-k8s-operator/api/v1alpha1"
+	"github.com/morganleroi/deploy-website-k8s-operator/controllers/deploy"
+)
+
+// withStorageAccess sets the account keys and the endpoint of the Webapp on the deployment parameters.
+// The endpoint defaults to the operator one.
+func withStorageAccess(deploymentParameters deploy.Parameters, webapp *webappv1alpha1.Webapp, defaultEndpoint webappv1alpha1.StorageEndpoint) deploy.Parameters {
+	deploymentParameters.AccountKey = &webapp.Spec.AzureStorageAccountKey
+	deploymentParameters.Package.AccountKey = &webapp.Spec.AzurePackageStorageAccountKey
+
+	endpoint := defaultEndpoint
+	if webapp.Spec.Endpoint != nil {
+		endpoint = *webapp.Spec.Endpoint
+	}
+	cloud := string(endpoint.Cloud)
+	deploymentParameters.Endpoint = &deploy.Endpoint{
+		Cloud:        &cloud,
+		BlobEndpoint: &endpoint.BlobEndpoint,
+		PathStyle:    &endpoint.PathStyle,
+	}
+	return deploymentParameters
+}
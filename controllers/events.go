@@ -0,0 +1,41 @@
+package controllers
+
+import (
+	"github.com/morganleroi/deploy-website-k8s-operator/controllers/deploy"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"time"
+)
+
+// eventObserver publishes a Kubernetes Event on the Webapp, or the preview, for each deployment step.
+// There is no prune step: deployments leave the stale files in place, only the cleanup of a preview deletes files.
+type eventObserver struct {
+	deploy.NoopObserver
+	recorder record.EventRecorder
+	webapp   client.Object
+}
+
+// routineSteps run on every reconciliation, even when nothing is deployed: only their failures are published
+var routineSteps = map[deploy.Step]bool{
+	deploy.StepVersionCheck: true,
+	deploy.StepResolve:      true,
+}
+
+func (o eventObserver) StepStarted(step deploy.Step) {
+	if routineSteps[step] {
+		return
+	}
+	o.recorder.Eventf(o.webapp, corev1.EventTypeNormal, string(step)+"Started", "%s started", step)
+}
+
+func (o eventObserver) StepFinished(step deploy.Step, duration time.Duration, err error) {
+	if err != nil {
+		o.recorder.Eventf(o.webapp, corev1.EventTypeWarning, string(step)+"Failed", "%s failed after %s: %v", step, duration.Round(time.Millisecond), err)
+		return
+	}
+	if routineSteps[step] {
+		return
+	}
+	o.recorder.Eventf(o.webapp, corev1.EventTypeNormal, string(step)+"Succeeded", "%s succeeded in %s", step, duration.Round(time.Millisecond))
+}
@@ -0,0 +1,73 @@
+package controllers
+
+import (
+	"fmt"
+	webappv1alpha1 "github.com/morganleroi/deploy-website-k8s-operator/api/v1alpha1"
+	"strings"
+)
+
+// location is a folder of a storage container, the whole container when the prefix is empty
+type location struct {
+	storageName   string
+	containerName string
+	// prefix ends with a slash unless empty
+	prefix string
+}
+
+func newLocation(storageName string, containerName string, prefix string) location {
+	prefix = strings.Trim(prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	return location{storageName: strings.ToLower(storageName), containerName: strings.ToLower(containerName), prefix: prefix}
+}
+
+// overlaps tells whether a file can belong to both locations
+func (l location) overlaps(other location) bool {
+	return l.storageName == other.storageName && l.containerName == other.containerName &&
+		(strings.HasPrefix(l.prefix, other.prefix) || strings.HasPrefix(other.prefix, l.prefix))
+}
+
+func (l location) String() string {
+	return fmt.Sprintf("%s/%s/%s", l.storageName, l.containerName, l.prefix)
+}
+
+// claimedLocations lists the locations a Webapp deploys to and its package container
+func claimedLocations(webapp *webappv1alpha1.Webapp) []location {
+	spec := webapp.Spec
+	return append(deployedLocations(webapp), newLocation(spec.PackageStorageName, spec.PackageContainerName, ""))
+}
+
+// deployedLocations lists the locations a Webapp deploys to: its targets, its blue/green slots or its container
+func deployedLocations(webapp *webappv1alpha1.Webapp) []location {
+	spec := webapp.Spec
+	var locations []location
+
+	switch {
+	case len(spec.Targets) > 0:
+		for _, target := range spec.Targets {
+			storageName, containerName := spec.StorageName, spec.ContainerName
+			if target.StorageName != "" {
+				storageName = target.StorageName
+			}
+			if target.ContainerName != "" {
+				containerName = target.ContainerName
+			}
+			locations = append(locations, newLocation(storageName, containerName, target.Prefix))
+		}
+	case spec.BlueGreen != nil && spec.BlueGreen.Mode == webappv1alpha1.SlotModeContainers:
+		locations = append(locations,
+			newLocation(spec.StorageName, spec.BlueGreen.Blue, ""),
+			newLocation(spec.StorageName, spec.BlueGreen.Green, ""),
+			// The pointer file, if any, is written at the root of the Webapp container
+			newLocation(spec.StorageName, spec.ContainerName, ""))
+	case spec.BlueGreen != nil:
+		locations = append(locations,
+			newLocation(spec.StorageName, spec.ContainerName, spec.BlueGreen.Blue),
+			newLocation(spec.StorageName, spec.ContainerName, spec.BlueGreen.Green),
+			newLocation(spec.StorageName, spec.ContainerName, ""))
+	default:
+		locations = append(locations, newLocation(spec.StorageName, spec.ContainerName, ""))
+	}
+	return locations
+}
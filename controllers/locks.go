@@ -0,0 +1,46 @@
+package controllers
+
+import (
+	"fmt"
+	"k8s.io/apimachinery/pkg/types"
+	"strings"
+	"sync"
+)
+
+// deploymentTarget identifies a storage container a Webapp deploys to
+func deploymentTarget(storageName string, containerName string) string {
+	return strings.ToLower(fmt.Sprintf("%s/%s", storageName, containerName))
+}
+
+// targetLocks prevents concurrent reconciliations from deploying to the same target at the same time
+type targetLocks struct {
+	mu      sync.Mutex
+	holders map[string]types.NamespacedName
+}
+
+// tryLock locks the target for the given Webapp without waiting.
+// When the target is already locked, it returns false and the Webapp holding the lock.
+func (l *targetLocks) tryLock(target string, webapp types.NamespacedName) (types.NamespacedName, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.holders == nil {
+		l.holders = map[string]types.NamespacedName{}
+	}
+
+	if holder, locked := l.holders[target]; locked && holder != webapp {
+		return holder, false
+	}
+	l.holders[target] = webapp
+	return webapp, true
+}
+
+// unlock releases the target if it is held by the given Webapp
+func (l *targetLocks) unlock(target string, webapp types.NamespacedName) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.holders[target] == webapp {
+		delete(l.holders, target)
+	}
+}
@@ -0,0 +1,18 @@
+package controllers
+
+import (
+	webappv1alpha1 "github.com/morganleroi/deploy-website-k8s-operator/api/v1alpha1"
+	"github.com/morganleroi/deploy-website-k8s-operator/controllers/deploy"
+)
+
+// withVersionMarker sets the version marker of the Webapp on the deployment parameters, blob tags when not configured
+func withVersionMarker(deploymentParameters deploy.Parameters, webapp *webappv1alpha1.Webapp) deploy.Parameters {
+	if webapp.Spec.VersionMarker == nil {
+		return deploymentParameters
+	}
+	strategy := string(webapp.Spec.VersionMarker.Strategy)
+	fileName := webapp.Spec.VersionMarker.FileName
+	deploymentParameters.Marker = &strategy
+	deploymentParameters.MarkerFileName = &fileName
+	return deploymentParameters
+}
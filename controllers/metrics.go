@@ -0,0 +1,136 @@
+package controllers
+
+import (
+	"github.com/morganleroi/deploy-website-k8s-operator/controllers/deploy"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sync"
+	"time"
+)
+
+const (
+	outcomeSuccess = "success"
+	outcomeFailure = "failure"
+)
+
+var (
+	deploymentsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "webapp_deployments_total",
+		Help: "Number of deployments by webapp and outcome",
+	}, []string{"namespace", "webapp", "outcome"})
+
+	deploymentDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "webapp_deployment_duration_seconds",
+		Help:    "Duration of the deployments by webapp and outcome",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 12),
+	}, []string{"namespace", "webapp", "outcome"})
+
+	stepDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "webapp_deployment_step_duration_seconds",
+		Help:    "Duration of each deployment step (Resolve, VersionCheck, Download, Extract, Upload, Plan, Switch, Cleanup, Verify)",
+		Buckets: prometheus.ExponentialBuckets(0.1, 2, 14),
+	}, []string{"namespace", "webapp", "preview", "step", "outcome"})
+
+	transferredBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "webapp_deployment_bytes_total",
+		Help: "Bytes downloaded from the package storage or uploaded to the target storage",
+	}, []string{"namespace", "webapp", "preview", "direction"})
+
+	deployedFiles = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "webapp_deployment_files_total",
+		Help: "Files handled by the deployments and the cleanups by action (uploaded, deleted)",
+	}, []string{"namespace", "webapp", "preview", "action"})
+
+	deployedVersionInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "webapp_deployed_version_info",
+		Help: "Version currently deployed for each webapp, the value is always 1",
+	}, []string{"namespace", "webapp", "version"})
+
+	lastSuccessfulDeployment = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "webapp_last_successful_deployment_timestamp_seconds",
+		Help: "Unix timestamp of the last successful deployment, use time() - value to get the time since",
+	}, []string{"namespace", "webapp"})
+
+	// deployedVersions remembers the version exposed by deployedVersionInfo to remove the old series on change
+	deployedVersions     = map[types.NamespacedName]string{}
+	deployedVersionsLock sync.Mutex
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		deploymentsTotal,
+		deploymentDuration,
+		stepDuration,
+		transferredBytes,
+		deployedFiles,
+		deployedVersionInfo,
+		lastSuccessfulDeployment,
+	)
+}
+
+// recordDeploymentMetrics updates the metrics once a deployment is done
+func recordDeploymentMetrics(webapp types.NamespacedName, outcome string, duration time.Duration) {
+	deploymentsTotal.WithLabelValues(webapp.Namespace, webapp.Name, outcome).Inc()
+	deploymentDuration.WithLabelValues(webapp.Namespace, webapp.Name, outcome).Observe(duration.Seconds())
+	if outcome == outcomeSuccess {
+		lastSuccessfulDeployment.WithLabelValues(webapp.Namespace, webapp.Name).SetToCurrentTime()
+	}
+}
+
+// setDeployedVersionMetric exposes the deployed version, replacing the previously exposed one
+func setDeployedVersionMetric(webapp types.NamespacedName, version string) {
+	deployedVersionsLock.Lock()
+	defer deployedVersionsLock.Unlock()
+
+	if previous, ok := deployedVersions[webapp]; ok {
+		if previous == version {
+			return
+		}
+		deployedVersionInfo.DeleteLabelValues(webapp.Namespace, webapp.Name, previous)
+	}
+	deployedVersions[webapp] = version
+	deployedVersionInfo.WithLabelValues(webapp.Namespace, webapp.Name, version).Set(1)
+}
+
+// forgetWebappMetrics removes the gauges of a deleted webapp, counters and histograms are kept
+func forgetWebappMetrics(webapp types.NamespacedName) {
+	deployedVersionsLock.Lock()
+	defer deployedVersionsLock.Unlock()
+
+	if version, ok := deployedVersions[webapp]; ok {
+		deployedVersionInfo.DeleteLabelValues(webapp.Namespace, webapp.Name, version)
+		delete(deployedVersions, webapp)
+	}
+	lastSuccessfulDeployment.DeleteLabelValues(webapp.Namespace, webapp.Name)
+}
+
+// metricsObserver records the step durations and transferred data of a deployment
+type metricsObserver struct {
+	webapp types.NamespacedName
+	// preview is the name of the preview of the Webapp being deployed, empty for the Webapp itself
+	preview string
+}
+
+func (o metricsObserver) StepStarted(deploy.Step) {}
+
+func (o metricsObserver) StepFinished(step deploy.Step, duration time.Duration, err error) {
+	outcome := outcomeSuccess
+	if err != nil {
+		outcome = outcomeFailure
+	}
+	stepDuration.WithLabelValues(o.webapp.Namespace, o.webapp.Name, o.preview, string(step), outcome).Observe(duration.Seconds())
+}
+
+func (o metricsObserver) PackageDownloaded(size int64) {
+	transferredBytes.WithLabelValues(o.webapp.Namespace, o.webapp.Name, o.preview, "download").Add(float64(size))
+}
+
+func (o metricsObserver) FileUploaded(_ string, size int64) {
+	transferredBytes.WithLabelValues(o.webapp.Namespace, o.webapp.Name, o.preview, "upload").Add(float64(size))
+	deployedFiles.WithLabelValues(o.webapp.Namespace, o.webapp.Name, o.preview, "uploaded").Inc()
+}
+
+func (o metricsObserver) FileDeleted(string) {
+	deployedFiles.WithLabelValues(o.webapp.Namespace, o.webapp.Name, o.preview, "deleted").Inc()
+}
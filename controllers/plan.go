@@ -0,0 +1,43 @@
+package controllers
+
+import (
+	webappv1alpha1 "github.com/morganleroi/deploy-website-k8s-operator/api/v1alpha1"
+	"github.com/morganleroi/deploy-website-k8s-operator/controllers/deploy"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// dryRunRequested tells whether the Webapp only asks for a deployment plan, through the spec or the annotation
+func dryRunRequested(webapp *webappv1alpha1.Webapp) bool {
+	return webapp.Spec.DryRun || webapp.Annotations[webappv1alpha1.DryRunAnnotation] == "true"
+}
+
+// planStatus converts a deployment plan into its status representation, listing at most MaxPlannedFiles files
+func planStatus(plan *deploy.Plan, version string, plannedAt v1.Time) *webappv1alpha1.DeploymentPlan {
+	status := &webappv1alpha1.DeploymentPlan{
+		Version:         version,
+		DeployedVersion: plan.DeployedVersion,
+		Added:           len(plan.Added),
+		Changed:         len(plan.Changed),
+		Stale:           len(plan.Stale),
+		Unchanged:       plan.Unchanged,
+		PlannedAt:       plannedAt,
+	}
+
+	for _, change := range []struct {
+		action string
+		files  []string
+	}{
+		{"Add", plan.Added},
+		{"Change", plan.Changed},
+		{"Stale", plan.Stale},
+	} {
+		for _, file := range change.files {
+			if len(status.Files) == webappv1alpha1.MaxPlannedFiles {
+				status.Truncated = true
+				return status
+			}
+			status.Files = append(status.Files, webappv1alpha1.PlannedFile{Path: file, Action: change.action})
+		}
+	}
+	return status
+}
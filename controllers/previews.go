@@ -0,0 +1,68 @@
+package controllers
+
+import (
+	"context"
+	webappv1alpha1 "github.com/morganleroi/deploy-website-k8s-operator/api/v1alpha1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"strings"
+	"time"
+)
+
+// previewCleanupRequeueDelay is the delay before checking again whether the previews of a deleted Webapp are gone
+const previewCleanupRequeueDelay = 5 * time.Second
+
+// deletePreviews deletes the previews of a deleted Webapp and releases its finalizer once they are gone.
+// The previews remove their files with the Webapp credentials, the Webapp is kept until then.
+func (r *WebappReconciler) deletePreviews(ctx context.Context, webapp *webappv1alpha1.Webapp) (ctrl.Result, error) {
+	if !controllerutil.ContainsFinalizer(webapp, webappv1alpha1.PreviewsFinalizer) {
+		return ctrl.Result{}, nil
+	}
+
+	previews := &webappv1alpha1.WebappPreviewList{}
+	if err := r.List(ctx, previews, client.InNamespace(webapp.Namespace)); err != nil {
+		return ctrl.Result{}, err
+	}
+	remaining := 0
+	for i := range previews.Items {
+		preview := &previews.Items[i]
+		if preview.Spec.WebappName != webapp.Name {
+			continue
+		}
+		remaining++
+		if preview.DeletionTimestamp.IsZero() {
+			if err := r.Delete(ctx, preview); client.IgnoreNotFound(err) != nil {
+				return ctrl.Result{}, err
+			}
+		}
+	}
+	if remaining > 0 {
+		log.FromContext(ctx).Info("Waiting for the previews to be deleted", "previews", remaining)
+		return ctrl.Result{RequeueAfter: previewCleanupRequeueDelay}, nil
+	}
+
+	controllerutil.RemoveFinalizer(webapp, webappv1alpha1.PreviewsFinalizer)
+	return ctrl.Result{}, r.Update(ctx, webapp)
+}
+
+// previewPrefixes returns the prefixes of the previews deployed in the Webapp container, which are not part of its plans
+func (r *WebappReconciler) previewPrefixes(ctx context.Context, webapp *webappv1alpha1.Webapp) ([]string, error) {
+	previews := &webappv1alpha1.WebappPreviewList{}
+	if err := r.List(ctx, previews, client.InNamespace(webapp.Namespace)); err != nil {
+		return nil, err
+	}
+
+	var prefixes []string
+	for i := range previews.Items {
+		preview := &previews.Items[i]
+		if preview.Spec.WebappName != webapp.Name {
+			continue
+		}
+		if containerName, prefix := previewLocation(preview, webapp); strings.EqualFold(containerName, webapp.Spec.ContainerName) {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	return prefixes, nil
+}
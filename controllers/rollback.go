@@ -0,0 +1,68 @@
+package controllers
+
+import (
+	"fmt"
+	webappv1alpha1 "github.com/morganleroi/deploy-website-k8s-operator/api/v1alpha1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"strconv"
+)
+
+// rollbackTrigger returns the requested rollback target, the spec field taking precedence over the annotation
+func rollbackTrigger(webapp *webappv1alpha1.Webapp) string {
+	if webapp.Spec.RollbackTo != "" {
+		return webapp.Spec.RollbackTo
+	}
+	return webapp.Annotations[webappv1alpha1.RollbackAnnotation]
+}
+
+// resolveRollback finds in the deployment history the version referenced by a rollback trigger
+func resolveRollback(webapp *webappv1alpha1.Webapp, trigger string) (string, error) {
+	history := webapp.Status.History
+
+	if trigger == webappv1alpha1.RollbackPrevious {
+		if len(history) < 2 {
+			return "", fmt.Errorf("unable to rollback to previous version: no previous deployment in history")
+		}
+		return history[len(history)-2].Version, nil
+	}
+
+	revision, err := strconv.ParseInt(trigger, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid rollback target %q: expecting a revision number or %q", trigger, webappv1alpha1.RollbackPrevious)
+	}
+
+	for _, record := range history {
+		if record.Revision == revision {
+			return record.Version, nil
+		}
+	}
+	return "", fmt.Errorf("unable to rollback to revision %d: revision not found in history", revision)
+}
+
+// clearRollbackTrigger pins the spec on the rolled back version so the next reconciliation does not redeploy the newer one
+func clearRollbackTrigger(webapp *webappv1alpha1.Webapp, version string) {
+	webapp.Spec.VersionToDeploy = version
+	if webapp.Spec.VersionPolicy != nil {
+		webapp.Spec.VersionPolicy.Mode = webappv1alpha1.VersionModePinned
+	}
+	webapp.Spec.RollbackTo = ""
+	delete(webapp.Annotations, webappv1alpha1.RollbackAnnotation)
+}
+
+// recordDeployment appends a new revision to the history, keeping at most MaxHistoryLength entries
+func recordDeployment(status *webappv1alpha1.WebappStatus, version string, deployedAt v1.Time) {
+	revision := int64(1)
+	if len(status.History) > 0 {
+		revision = status.History[len(status.History)-1].Revision + 1
+	}
+
+	status.History = append(status.History, webappv1alpha1.DeploymentRecord{
+		Revision:   revision,
+		Version:    version,
+		DeployedAt: deployedAt,
+	})
+
+	if len(status.History) > webappv1alpha1.MaxHistoryLength {
+		status.History = status.History[len(status.History)-webappv1alpha1.MaxHistoryLength:]
+	}
+}
@@ -0,0 +1,58 @@
+package controllers
+
+import (
+	"context"
+	webappv1alpha1 "github.com/morganleroi/deploy-website-k8s-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// suspension returns the reason and the message of the Suspended condition, no reason when the Webapp is not suspended
+func suspension(webapp *webappv1alpha1.Webapp, suspendAll bool) (string, string) {
+	switch {
+	case webapp.Spec.Suspend:
+		return reasonSuspendedBySpec, "Deployments are suspended by spec.suspend of Webapp " + webapp.Name
+	case suspendAll:
+		return reasonSuspendedByOperator, "Deployments of every Webapp are suspended by the operator --suspend-all flag"
+	}
+	return "", ""
+}
+
+// reportSuspended sets the Suspended condition, the status is only updated when the condition changes
+func reportSuspended(ctx context.Context, c client.Client, recorder record.EventRecorder, object client.Object, conditions *[]v1.Condition, reason string, message string) error {
+	current := meta.FindStatusCondition(*conditions, conditionSuspended)
+	if current != nil && current.Status == v1.ConditionTrue && current.Reason == reason {
+		return nil
+	}
+
+	log.FromContext(ctx).Info("Deployments suspended", "reason", reason)
+	recorder.Event(object, corev1.EventTypeNormal, reason, message)
+	meta.SetStatusCondition(conditions, v1.Condition{
+		Type:    conditionSuspended,
+		Status:  v1.ConditionTrue,
+		Reason:  reason,
+		Message: message,
+	})
+	return c.Status().Update(ctx, object)
+}
+
+// reportResumed clears the Suspended condition of an object which is no longer suspended, before it is reconciled
+func reportResumed(ctx context.Context, c client.Client, recorder record.EventRecorder, object client.Object, conditions *[]v1.Condition) error {
+	if !meta.IsStatusConditionTrue(*conditions, conditionSuspended) {
+		return nil
+	}
+
+	log.FromContext(ctx).Info("Deployments resumed")
+	recorder.Event(object, corev1.EventTypeNormal, reasonResumed, "Deployments resumed from the latest spec")
+	meta.SetStatusCondition(conditions, v1.Condition{
+		Type:    conditionSuspended,
+		Status:  v1.ConditionFalse,
+		Reason:  reasonResumed,
+		Message: "Deployments resumed from the latest spec",
+	})
+	return c.Status().Update(ctx, object)
+}
@@ -0,0 +1,130 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	webappv1alpha1 "github.com/morganleroi/deploy-website-k8s-operator/api/v1alpha1"
+	"github.com/morganleroi/deploy-website-k8s-operator/controllers/deploy"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"strings"
+)
+
+// deployTargets deploys the version to the targets of the Webapp in order and reports each of them in the status.
+// A new version is verified once every target is deployed.
+func (r *WebappReconciler) deployTargets(ctx context.Context, webapp *webappv1alpha1.Webapp, deploymentParameters deploy.Parameters, observer deploy.Observer) error {
+	if webapp.Spec.BlueGreen != nil {
+		return fmt.Errorf("blueGreen is not supported with targets")
+	}
+	version := *deploymentParameters.VersionToDeploy
+
+	results, err := deploy.StartTargetsDeployment(ctx, targetParameters(deploymentParameters, webapp), observer)
+	webapp.Status.Targets = targetStatuses(webapp, results, v1.Now())
+	if err != nil {
+		return fmt.Errorf("deployment of target %s failed: %w", webapp.Spec.Targets[len(results)-1].Name, err)
+	}
+	log.FromContext(ctx).Info("Targets deployed", "targets", len(results))
+
+	if webapp.Status.DeployedVersion == version {
+		return nil
+	}
+	return r.verifySite(ctx, webapp, version, "", observer)
+}
+
+// targetParameters returns the deployment parameters of each target, the Webapp ones completed by the target
+func targetParameters(deploymentParameters deploy.Parameters, webapp *webappv1alpha1.Webapp) []deploy.Parameters {
+	targets := make([]deploy.Parameters, 0, len(webapp.Spec.Targets))
+	for i := range webapp.Spec.Targets {
+		target := &webapp.Spec.Targets[i]
+		parameters := deploymentParameters
+		parameters.PackagePath = &target.Path
+		if target.StorageName != "" && target.StorageName != webapp.Spec.StorageName {
+			// The account key of the Webapp belongs to its storage account, the others are accessed with the SPN
+			credential := *deploymentParameters.AzureCredential
+			noAccountKey := ""
+			credential.AccountKey = &noAccountKey
+			parameters.AzureCredential = &credential
+			parameters.StorageName = &target.StorageName
+			parameters.ExcludedPrefixes = nil
+		}
+		if target.ContainerName != "" && target.ContainerName != webapp.Spec.ContainerName {
+			parameters.ContainerName = &target.ContainerName
+			// The excluded previews are in the Webapp container
+			parameters.ExcludedPrefixes = nil
+		}
+		if target.Prefix != "" {
+			prefix := strings.Trim(target.Prefix, "/") + "/"
+			parameters.Prefix = &prefix
+		}
+		if target.FileNameToCheck != "" {
+			parameters.FileNameToCheck = &target.FileNameToCheck
+		}
+		if target.VersionMarker != nil {
+			strategy := string(target.VersionMarker.Strategy)
+			parameters.Marker = &strategy
+			parameters.MarkerFileName = &target.VersionMarker.FileName
+		}
+		targets = append(targets, parameters)
+	}
+	return targets
+}
+
+// targetStatuses reports the result of each target reached by the deployment, the following ones are pending
+func targetStatuses(webapp *webappv1alpha1.Webapp, results []deploy.TargetResult, now v1.Time) []webappv1alpha1.TargetStatus {
+	previous := make(map[string]webappv1alpha1.TargetStatus, len(webapp.Status.Targets))
+	for _, status := range webapp.Status.Targets {
+		previous[status.Name] = status
+	}
+
+	statuses := make([]webappv1alpha1.TargetStatus, 0, len(webapp.Spec.Targets))
+	for i, target := range webapp.Spec.Targets {
+		status := previous[target.Name]
+		status.Name = target.Name
+		status.Message = ""
+		switch {
+		case i >= len(results):
+			status.Status = "PENDING"
+			status.Message = fmt.Sprintf("Waiting for target %s to be deployed", webapp.Spec.Targets[len(results)-1].Name)
+		case results[i].Err != nil:
+			status.Status = "ERROR"
+			status.Message = results[i].Err.Error()
+		default:
+			status.Status = "SUCCESS"
+		}
+		if i < len(results) {
+			status.DeployedVersion = results[i].DeployedVersion
+			if results[i].Deployed {
+				deployedAt := now
+				status.DeployedAt = &deployedAt
+			}
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// planTargets computes the plan of every target, the files of each target being listed under its name
+func planTargets(ctx context.Context, webapp *webappv1alpha1.Webapp, deploymentParameters deploy.Parameters, observer deploy.Observer) (*deploy.Plan, error) {
+	plans, err := deploy.PlanTargets(ctx, targetParameters(deploymentParameters, webapp), observer)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := &deploy.Plan{}
+	for i, plan := range plans {
+		name := webapp.Spec.Targets[i].Name
+		if merged.DeployedVersion == "" {
+			merged.DeployedVersion = plan.DeployedVersion
+		}
+		for _, files := range []struct {
+			merged *[]string
+			target []string
+		}{{&merged.Added, plan.Added}, {&merged.Changed, plan.Changed}, {&merged.Stale, plan.Stale}} {
+			for _, fileName := range files.target {
+				*files.merged = append(*files.merged, name+"/"+fileName)
+			}
+		}
+		merged.Unchanged += plan.Unchanged
+	}
+	return merged, nil
+}
@@ -0,0 +1,254 @@
+// Package trigger receives the notifications of new packages, Azure Event Grid BlobCreated events or signed webhooks,
+// and enqueues the Webapps deploying from the package container without waiting for their next poll.
+package trigger
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"k8s.io/apimachinery/pkg/types"
+	"net"
+	"net/http"
+	"net/url"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"strings"
+	"time"
+)
+
+const (
+	// EventGridPath receives the Azure Event Grid events, the subscription must pass the secret in the code query parameter
+	EventGridPath = "/eventgrid"
+	// WebhookPath receives the generic webhooks, signed with SignatureHeader
+	WebhookPath = "/webhook"
+	// SignatureHeader holds the hex encoded HMAC-SHA256 of the webhook body, prefixed by "sha256="
+	SignatureHeader = "X-Signature-256"
+
+	eventTypeValidation  = "Microsoft.EventGrid.SubscriptionValidationEvent"
+	eventTypeBlobCreated = "Microsoft.Storage.BlobCreated"
+	packageExtension     = ".zip"
+	maxBodySize          = 1 << 20
+	shutdownTimeout      = 10 * time.Second
+)
+
+// Resolver finds the Webapps deploying packages from a storage account container
+type Resolver interface {
+	WebappsForPackage(ctx context.Context, storageName string, containerName string) ([]types.NamespacedName, error)
+}
+
+// NewObject creates an object identifying the Webapp to enqueue, only its name and namespace are used
+type NewObject func(webapp types.NamespacedName) client.Object
+
+// Receiver is the HTTP server receiving the package notifications.
+// It sends a GenericEvent on Events for each Webapp to reconcile.
+type Receiver struct {
+	BindAddress string
+	Secret      []byte
+	Resolver    Resolver
+	NewObject   NewObject
+	Events      chan<- event.GenericEvent
+	// PathStyle tells the blob URLs carry the storage account in their path instead of their host, as on Azurite.
+	// URLs whose host is an IP address or a single label are always read path-style.
+	PathStyle bool
+}
+
+// eventGridEvent is the part of the Event Grid schema used by the receiver
+type eventGridEvent struct {
+	EventType string `json:"eventType"`
+	Subject   string `json:"subject"`
+	Data      struct {
+		Url            string `json:"url"`
+		ValidationCode string `json:"validationCode"`
+	} `json:"data"`
+}
+
+// Webhook is the body of the generic webhook
+type Webhook struct {
+	StorageName   string `json:"storageName"`
+	ContainerName string `json:"containerName"`
+	BlobName      string `json:"blobName"`
+}
+
+// Handler returns the HTTP handler serving both the Event Grid and the webhook endpoints
+func (r *Receiver) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(EventGridPath, r.handleEventGrid)
+	mux.HandleFunc(WebhookPath, r.handleWebhook)
+	return mux
+}
+
+// Start serves the receiver until the context is cancelled, it implements manager.Runnable
+func (r *Receiver) Start(ctx context.Context) error {
+	server := &http.Server{
+		Addr:              r.BindAddress,
+		Handler:           r.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+
+	log.FromContext(ctx).Info("Starting the package trigger receiver", "address", r.BindAddress)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// NeedLeaderElection makes only the leader receive the notifications, the controller consuming them runs on the leader
+func (r *Receiver) NeedLeaderElection() bool {
+	return true
+}
+
+func (r *Receiver) handleEventGrid(w http.ResponseWriter, req *http.Request) {
+	logger := log.FromContext(req.Context()).WithValues("endpoint", EventGridPath)
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(req.URL.Query().Get("code")), r.Secret) != 1 {
+		http.Error(w, "invalid code", http.StatusUnauthorized)
+		return
+	}
+
+	var events []eventGridEvent
+	if err := json.NewDecoder(io.LimitReader(req.Body, maxBodySize)).Decode(&events); err != nil {
+		http.Error(w, fmt.Sprintf("invalid events: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	for _, e := range events {
+		switch e.EventType {
+		case eventTypeValidation:
+			logger.Info("Event Grid subscription validated")
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(map[string]string{"validationResponse": e.Data.ValidationCode})
+			return
+		case eventTypeBlobCreated:
+			storageName, containerName, blobName, err := parseBlobUrl(e.Data.Url, r.PathStyle)
+			if err != nil {
+				logger.Info("Ignoring BlobCreated event", "url", e.Data.Url, "error", err.Error())
+				continue
+			}
+			if err := r.enqueue(req.Context(), storageName, containerName, blobName); err != nil {
+				logger.Error(err, "Unable to enqueue the Webapps", "url", e.Data.Url)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		default:
+			logger.V(1).Info("Ignoring event", "eventType", e.EventType, "subject", e.Subject)
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (r *Receiver) handleWebhook(w http.ResponseWriter, req *http.Request) {
+	logger := log.FromContext(req.Context()).WithValues("endpoint", WebhookPath)
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(req.Body, maxBodySize))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("unable to read the body: %v", err), http.StatusBadRequest)
+		return
+	}
+	if !validSignature(r.Secret, body, req.Header.Get(SignatureHeader)) {
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	var webhook Webhook
+	if err := json.Unmarshal(body, &webhook); err != nil {
+		http.Error(w, fmt.Sprintf("invalid webhook: %v", err), http.StatusBadRequest)
+		return
+	}
+	if webhook.StorageName == "" || webhook.ContainerName == "" {
+		http.Error(w, "storageName and containerName are required", http.StatusBadRequest)
+		return
+	}
+
+	if err := r.enqueue(req.Context(), webhook.StorageName, webhook.ContainerName, webhook.BlobName); err != nil {
+		logger.Error(err, "Unable to enqueue the Webapps", "storage", webhook.StorageName, "container", webhook.ContainerName)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// enqueue sends an event for each Webapp deploying from the container, blobs other than packages are ignored
+func (r *Receiver) enqueue(ctx context.Context, storageName string, containerName string, blobName string) error {
+	if blobName != "" && !strings.HasSuffix(blobName, packageExtension) {
+		return nil
+	}
+
+	webapps, err := r.Resolver.WebappsForPackage(ctx, storageName, containerName)
+	if err != nil {
+		return err
+	}
+
+	logger := log.FromContext(ctx)
+	for _, webapp := range webapps {
+		select {
+		case r.Events <- event.GenericEvent{Object: r.NewObject(webapp)}:
+			logger.Info("Webapp enqueued by a package notification", "webapp", webapp, "package", blobName)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Sign returns the SignatureHeader value of a webhook body
+func Sign(secret []byte, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func validSignature(secret []byte, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
+
+// parseBlobUrl extracts the storage account, the container and the blob name from a blob URL
+// such as https://account.blob.core.windows.net/container/path/to/blob.zip,
+// or http://127.0.0.1:10000/account/container/path/to/blob.zip path-style
+func parseBlobUrl(blobUrl string, pathStyle bool) (string, string, string, error) {
+	parsed, err := url.Parse(blobUrl)
+	if err != nil {
+		return "", "", "", err
+	}
+	path := strings.TrimPrefix(parsed.Path, "/")
+	host := parsed.Hostname()
+
+	var storageName string
+	if pathStyle || net.ParseIP(host) != nil || !strings.Contains(host, ".") {
+		storageName, path, _ = strings.Cut(path, "/")
+		if storageName == "" {
+			return "", "", "", fmt.Errorf("no storage account in path %q", parsed.Path)
+		}
+	} else {
+		storageName, _, _ = strings.Cut(host, ".")
+		if storageName == "" {
+			return "", "", "", fmt.Errorf("no storage account in host %q", parsed.Host)
+		}
+	}
+	containerName, blobName, found := strings.Cut(path, "/")
+	if !found || containerName == "" || blobName == "" {
+		return "", "", "", fmt.Errorf("no container and blob in path %q", parsed.Path)
+	}
+	return storageName, containerName, blobName, nil
+}
@@ -0,0 +1,171 @@
+package trigger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"net/http"
+	"net/http/httptest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"testing"
+)
+
+var secret = []byte("s3cr3t")
+
+// fakeResolver maps "storage/container" to the Webapps deploying from it
+type fakeResolver map[string][]types.NamespacedName
+
+func (f fakeResolver) WebappsForPackage(_ context.Context, storageName string, containerName string) ([]types.NamespacedName, error) {
+	return f[storageName+"/"+containerName], nil
+}
+
+func newTestServer(t *testing.T) (*httptest.Server, chan event.GenericEvent) {
+	events := make(chan event.GenericEvent, 10)
+	receiver := &Receiver{
+		Secret: secret,
+		Resolver: fakeResolver{
+			"packages/releases": {{Namespace: "team-a", Name: "site"}, {Namespace: "team-b", Name: "docs"}},
+		},
+		NewObject: func(webapp types.NamespacedName) client.Object {
+			return &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: webapp.Namespace, Name: webapp.Name}}
+		},
+		Events: events,
+	}
+	server := httptest.NewServer(receiver.Handler())
+	t.Cleanup(server.Close)
+	return server, events
+}
+
+func post(t *testing.T, url string, body []byte, headers map[string]string) *http.Response {
+	request, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, value := range headers {
+		request.Header.Set(key, value)
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = response.Body.Close() })
+	return response
+}
+
+func enqueued(events chan event.GenericEvent) []string {
+	var names []string
+	for {
+		select {
+		case e := <-events:
+			names = append(names, client.ObjectKeyFromObject(e.Object).String())
+		default:
+			return names
+		}
+	}
+}
+
+func TestEventGridValidationHandshake(t *testing.T) {
+	server, _ := newTestServer(t)
+
+	body := []byte(`[{"eventType":"Microsoft.EventGrid.SubscriptionValidationEvent","data":{"validationCode":"512d38b6"}}]`)
+	response := post(t, server.URL+EventGridPath+"?code=s3cr3t", body, nil)
+
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", response.StatusCode)
+	}
+	var validation map[string]string
+	if err := json.NewDecoder(response.Body).Decode(&validation); err != nil {
+		t.Fatal(err)
+	}
+	if validation["validationResponse"] != "512d38b6" {
+		t.Fatalf("expected the validation code to be echoed, got %v", validation)
+	}
+}
+
+func TestEventGridBlobCreated(t *testing.T) {
+	server, events := newTestServer(t)
+
+	body := []byte(`[
+		{"eventType":"Microsoft.Storage.BlobCreated","data":{"url":"https://packages.blob.core.windows.net/releases/v1.2.3.master.zip"}},
+		{"eventType":"Microsoft.Storage.BlobCreated","data":{"url":"https://packages.blob.core.windows.net/releases/notes.txt"}},
+		{"eventType":"Microsoft.Storage.BlobCreated","data":{"url":"https://other.blob.core.windows.net/releases/v1.2.3.master.zip"}}
+	]`)
+	response := post(t, server.URL+EventGridPath+"?code=s3cr3t", body, nil)
+
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", response.StatusCode)
+	}
+	names := enqueued(events)
+	if len(names) != 2 || names[0] != "team-a/site" || names[1] != "team-b/docs" {
+		t.Fatalf("expected team-a/site and team-b/docs to be enqueued, got %v", names)
+	}
+}
+
+func TestEventGridRejectsInvalidCode(t *testing.T) {
+	server, events := newTestServer(t)
+
+	body := []byte(`[{"eventType":"Microsoft.Storage.BlobCreated","data":{"url":"https://packages.blob.core.windows.net/releases/v1.zip"}}]`)
+	for _, url := range []string{server.URL + EventGridPath, server.URL + EventGridPath + "?code=wrong"} {
+		response := post(t, url, body, nil)
+		if response.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("expected status 401 for %s, got %d", url, response.StatusCode)
+		}
+	}
+	if names := enqueued(events); len(names) != 0 {
+		t.Fatalf("expected no Webapp to be enqueued, got %v", names)
+	}
+}
+
+func TestWebhookSigned(t *testing.T) {
+	server, events := newTestServer(t)
+
+	body := []byte(`{"storageName":"packages","containerName":"releases","blobName":"v1.2.4.master.zip"}`)
+	response := post(t, server.URL+WebhookPath, body, map[string]string{SignatureHeader: Sign(secret, body)})
+
+	if response.StatusCode != http.StatusAccepted {
+		t.Fatalf("expected status 202, got %d", response.StatusCode)
+	}
+	if names := enqueued(events); len(names) != 2 {
+		t.Fatalf("expected 2 Webapps to be enqueued, got %v", names)
+	}
+}
+
+func TestWebhookRejectsInvalidSignature(t *testing.T) {
+	server, events := newTestServer(t)
+
+	body := []byte(`{"storageName":"packages","containerName":"releases","blobName":"v1.2.4.master.zip"}`)
+	for _, signature := range []string{"", "sha256=00", Sign([]byte("other"), body)} {
+		response := post(t, server.URL+WebhookPath, body, map[string]string{SignatureHeader: signature})
+		if response.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("expected status 401 for signature %q, got %d", signature, response.StatusCode)
+		}
+	}
+	if names := enqueued(events); len(names) != 0 {
+		t.Fatalf("expected no Webapp to be enqueued, got %v", names)
+	}
+}
+
+func TestParseBlobUrl(t *testing.T) {
+	for _, test := range []struct {
+		url       string
+		pathStyle bool
+	}{
+		{"https://packages.blob.core.windows.net/releases/site/v1.zip", false},
+		{"http://127.0.0.1:10000/packages/releases/site/v1.zip", false},
+		{"http://azurite:10000/packages/releases/site/v1.zip", false},
+		{"http://azurite.storage.svc:10000/packages/releases/site/v1.zip", true},
+	} {
+		storageName, containerName, blobName, err := parseBlobUrl(test.url, test.pathStyle)
+		if err != nil || storageName != "packages" || containerName != "releases" || blobName != "site/v1.zip" {
+			t.Fatalf("unexpected parsing of %s: %s %s %s %v", test.url, storageName, containerName, blobName, err)
+		}
+	}
+
+	if _, _, _, err := parseBlobUrl("https://packages.blob.core.windows.net/releases", false); err == nil {
+		t.Fatal("expected an error for a URL without blob")
+	}
+}
@@ -0,0 +1,37 @@
+package controllers
+
+import (
+	"context"
+	webappv1alpha1 "github.com/morganleroi/deploy-website-k8s-operator/api/v1alpha1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// packageIndexKey indexes the Webapps by package container to find the ones to reconcile when a package is published
+const packageIndexKey = ".spec.package"
+
+func indexWebappPackage(object client.Object) []string {
+	webapp := object.(*webappv1alpha1.Webapp)
+	return []string{deploymentTarget(webapp.Spec.PackageStorageName, webapp.Spec.PackageContainerName)}
+}
+
+// WebappsForPackage returns the Webapps deploying packages from the container, it implements trigger.Resolver
+func (r *WebappReconciler) WebappsForPackage(ctx context.Context, storageName string, containerName string) ([]types.NamespacedName, error) {
+	webapps := &webappv1alpha1.WebappList{}
+	err := r.List(ctx, webapps, client.MatchingFields{packageIndexKey: deploymentTarget(storageName, containerName)})
+	if err != nil {
+		return nil, err
+	}
+
+	webappNames := make([]types.NamespacedName, 0, len(webapps.Items))
+	for _, webapp := range webapps.Items {
+		webappNames = append(webappNames, client.ObjectKeyFromObject(&webapp))
+	}
+	return webappNames, nil
+}
+
+// NewTriggerObject identifies a Webapp to reconcile from a package notification
+func NewTriggerObject(webapp types.NamespacedName) client.Object {
+	return &webappv1alpha1.Webapp{ObjectMeta: v1.ObjectMeta{Namespace: webapp.Namespace, Name: webapp.Name}}
+}
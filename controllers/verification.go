@@ -0,0 +1,80 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	webappv1alpha1 "github.com/morganleroi/deploy-website-k8s-operator/api/v1alpha1"
+	"github.com/morganleroi/deploy-website-k8s-operator/controllers/deploy"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"strings"
+	"time"
+)
+
+const (
+	defaultVerificationRetryInterval = 10 * time.Second
+	defaultVerificationTimeout       = 10 * time.Second
+)
+
+// verifySite runs the verification checks of the Webapp, if any, against the deployed version.
+// The path prefix targets a blue/green slot.
+func (r *WebappReconciler) verifySite(ctx context.Context, webapp *webappv1alpha1.Webapp, version string, pathPrefix string, observer deploy.Observer) error {
+	verification := webapp.Spec.Verification
+	if verification == nil {
+		return nil
+	}
+
+	baseUrl := verification.BaseUrl
+	if baseUrl == "" {
+		baseUrl = webapp.Spec.SiteUrl
+	}
+	if baseUrl == "" {
+		return &deploy.VerificationError{Version: version, Err: fmt.Errorf("the verification requires a baseUrl or the Webapp siteUrl")}
+	}
+	if pathPrefix != "" {
+		baseUrl = strings.TrimSuffix(baseUrl, "/") + "/" + strings.Trim(pathPrefix, "/")
+	}
+
+	checks := make([]deploy.Check, 0, len(verification.Checks))
+	for _, check := range verification.Checks {
+		checks = append(checks, deploy.Check{
+			Path:           check.Path,
+			ExpectedStatus: check.ExpectedStatus,
+			Contains:       check.Contains,
+			VersionField:   check.VersionField,
+		})
+	}
+
+	return deploy.Verify(ctx, deploy.Verification{
+		BaseUrl:       baseUrl,
+		Checks:        checks,
+		Retries:       verification.Retries,
+		RetryInterval: durationOrDefault(verification.RetryInterval, defaultVerificationRetryInterval),
+		Timeout:       durationOrDefault(verification.Timeout, defaultVerificationTimeout),
+		Clock:         r.clock(),
+	}, version, observer)
+}
+
+// clock returns the injected clock, nil for the system one
+func (r *WebappReconciler) clock() deploy.Clock {
+	if r.Dependencies == nil {
+		return nil
+	}
+	return r.Dependencies.Clock
+}
+
+func durationOrDefault(duration *v1.Duration, defaultDuration time.Duration) time.Duration {
+	if duration == nil || duration.Duration <= 0 {
+		return defaultDuration
+	}
+	return duration.Duration
+}
+
+// verificationRollbackRevision returns the revision to roll back to when the verification of a new version fails,
+// zero when the Webapp does not ask for it or there is no revision to roll back to
+func verificationRollbackRevision(webapp *webappv1alpha1.Webapp) int64 {
+	if webapp.Spec.Verification == nil || !webapp.Spec.Verification.RollbackOnFailure || len(webapp.Status.History) == 0 {
+		return 0
+	}
+	// The failed version is not recorded in the history, its last revision is the one deployed before
+	return webapp.Status.History[len(webapp.Status.History)-1].Revision
+}
@@ -0,0 +1,36 @@
+package controllers
+
+import (
+	"github.com/morganleroi/deploy-website-k8s-operator/controllers/deploy"
+	"k8s.io/apimachinery/pkg/types"
+	"sync"
+)
+
+// versionCaches holds the version cache of each Webapp, so the versions of a deleted Webapp are forgotten with it
+type versionCaches struct {
+	mu     sync.Mutex
+	caches map[types.NamespacedName]*deploy.VersionCache
+}
+
+// of returns the version cache of the Webapp, created on first use
+func (c *versionCaches) of(webapp types.NamespacedName) *deploy.VersionCache {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.caches == nil {
+		c.caches = map[types.NamespacedName]*deploy.VersionCache{}
+	}
+	cache, ok := c.caches[webapp]
+	if !ok {
+		cache = deploy.NewVersionCache()
+		c.caches[webapp] = cache
+	}
+	return cache
+}
+
+// forget drops the version cache of a deleted Webapp
+func (c *versionCaches) forget(webapp types.NamespacedName) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.caches, webapp)
+}
@@ -0,0 +1,63 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	webappv1alpha1 "github.com/morganleroi/deploy-website-k8s-operator/api/v1alpha1"
+	"github.com/morganleroi/deploy-website-k8s-operator/controllers/deploy"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"time"
+)
+
+// defaultPollInterval is the delay between two lookups of the package container when the version policy does not define it
+const defaultPollInterval = 5 * time.Minute
+
+// tracksLatestVersion tells whether the Webapp deploys the latest package instead of spec.versionToDeploy
+func tracksLatestVersion(webapp *webappv1alpha1.Webapp) bool {
+	return webapp.Spec.VersionPolicy != nil && webapp.Spec.VersionPolicy.Mode == webappv1alpha1.VersionModeLatest
+}
+
+// pollInterval returns the delay between two lookups of the package container
+func pollInterval(webapp *webappv1alpha1.Webapp) time.Duration {
+	if webapp.Spec.VersionPolicy.PollInterval != nil && webapp.Spec.VersionPolicy.PollInterval.Duration > 0 {
+		return webapp.Spec.VersionPolicy.PollInterval.Duration
+	}
+	return defaultPollInterval
+}
+
+// resolveLatestVersion looks for the latest package matching the version policy and records it in the status.
+// On failure the status is marked as degraded, the caller is in charge of updating it.
+func (r *WebappReconciler) resolveLatestVersion(ctx context.Context, webapp *webappv1alpha1.Webapp, deploymentParameters deploy.Parameters) (string, error) {
+	logger := log.FromContext(ctx)
+	policy := webapp.Spec.VersionPolicy
+
+	// Only the metrics observe the lookup, events on each poll would flood the Webapp
+	versions, err := deploy.ListPackageVersions(ctx, deploymentParameters, metricsObserver{webapp: client.ObjectKeyFromObject(webapp)})
+	if err == nil {
+		var version string
+		version, err = deploy.LatestVersion(versions, policy.Constraint, policy.Channel)
+		if err == nil {
+			if version != webapp.Status.ResolvedVersion {
+				logger.Info("New version resolved", "resolvedVersion", version, "previousVersion", webapp.Status.ResolvedVersion)
+				r.Recorder.Eventf(webapp, corev1.EventTypeNormal, reasonVersionResolved, "Version %s is the latest package matching constraint %q on channel %q", version, policy.Constraint, policy.Channel)
+			}
+			webapp.Status.ResolvedVersion = version
+			return version, nil
+		}
+	}
+
+	logger.Error(err, "Unable to resolve the version to deploy")
+	webapp.Status.Status = "ERROR"
+	r.Recorder.Event(webapp, corev1.EventTypeWarning, reasonVersionResolutionFailed, err.Error())
+	meta.SetStatusCondition(&webapp.Status.Conditions, v1.Condition{
+		Type:    conditionDegraded,
+		Status:  v1.ConditionTrue,
+		Reason:  reasonVersionResolutionFailed,
+		Message: fmt.Sprintf("Unable to resolve the version to deploy: %v", err),
+	})
+	return "", err
+}
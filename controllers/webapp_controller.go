@@ -18,38 +18,124 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	webappv1alpha1 "github.com/morganleroi/deploy-website-k8s-operator/api/v1alpha1"
 	"github.com/morganleroi/deploy-website-k8s-operator/controllers/deploy"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+	"strconv"
 	"time"
 )
 
+var tracer = otel.Tracer("github.com/morganleroi/deploy-website-k8s-operator/controllers")
+
 // WebappReconciler reconciles a Webapp object
 type WebappReconciler struct {
 	client.Client
-	Scheme *runtime.Scheme
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	// DeploymentTimeout bounds a deployment when the Webapp does not define its own timeout
+	DeploymentTimeout time.Duration
+	// MaxConcurrentReconciles is the number of Webapps deployed in parallel
+	MaxConcurrentReconciles int
+	// FreezeConfigMap declares the cluster wide freeze periods, none when empty
+	FreezeConfigMap types.NamespacedName
+	// Triggers receives the Webapps to reconcile right away when a new package is published, if any
+	Triggers <-chan event.GenericEvent
+	// Endpoint locates the storage accounts of the Webapps which do not define their own endpoint
+	Endpoint webappv1alpha1.StorageEndpoint
+	// SuspendAll suspends every Webapp, like their spec.suspend
+	SuspendAll bool
+	// Dependencies replaces the storage, credentials or clock of the deployments, the defaults when nil
+	Dependencies *deploy.Dependencies
+
+	locks targetLocks
+	// versions caches the deployed version of each target between two reconciliations
+	versions versionCaches
 }
 
+// DefaultDeploymentTimeout is used when neither the reconciler nor the Webapp define a timeout
+const DefaultDeploymentTimeout = 15 * time.Minute
+
+// statusUpdateTimeout bounds the status update made after the reconcile context is cancelled
+const statusUpdateTimeout = 10 * time.Second
+
+// targetLockedRequeueDelay is the delay before retrying a deployment whose target is locked by another Webapp
+const targetLockedRequeueDelay = 30 * time.Second
+
 //+kubebuilder:rbac:groups=webapp.simpletest.com,resources=webapps,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=webapp.simpletest.com,resources=webapps/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=webapp.simpletest.com,resources=webapps/finalizers,verbs=update
+//+kubebuilder:rbac:groups=webapp.simpletest.com,resources=webapppreviews,verbs=get;list;watch;delete
 //+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;
-func (r *WebappReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-	_ = log.FromContext(ctx)
+//+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch
+
+func (r *WebappReconciler) Reconcile(ctx context.Context, req ctrl.Request) (result ctrl.Result, err error) {
+	logger := log.FromContext(ctx)
+
+	ctx, span := tracer.Start(ctx, "Reconcile", trace.WithAttributes(
+		attribute.String("webapp", req.Name),
+		attribute.String("namespace", req.Namespace),
+	))
+	defer span.End()
 
 	webAppCrd := &webappv1alpha1.Webapp{}
-	err := r.Get(ctx, req.NamespacedName, webAppCrd)
-	log.Log.Info("---------------------------")
-	log.Log.Info("Request name", "WebappVersion", req.Name)
+	err = r.Get(ctx, req.NamespacedName, webAppCrd)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			forgetWebappMetrics(req.NamespacedName)
+			r.versions.forget(req.NamespacedName)
+		}
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	if !webAppCrd.DeletionTimestamp.IsZero() {
+		return r.deletePreviews(ctx, webAppCrd)
+	}
+	if !controllerutil.ContainsFinalizer(webAppCrd, webappv1alpha1.PreviewsFinalizer) {
+		controllerutil.AddFinalizer(webAppCrd, webappv1alpha1.PreviewsFinalizer)
+		// The update enqueues the Webapp again
+		return ctrl.Result{}, r.Update(ctx, webAppCrd)
+	}
 
+	if reason, message := suspension(webAppCrd, r.SuspendAll); reason != "" {
+		// Nothing is deployed nor polled, unsuspending the Webapp enqueues a new reconciliation
+		return ctrl.Result{}, reportSuspended(ctx, r.Client, r.Recorder, webAppCrd, &webAppCrd.Status.Conditions, reason, message)
+	}
+	if err = reportResumed(ctx, r.Client, r.Recorder, webAppCrd, &webAppCrd.Status.Conditions); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if tracksLatestVersion(webAppCrd) {
+		defer func() {
+			// Poll the package container for new versions, unless an earlier reconciliation is already planned
+			if err == nil && (result.RequeueAfter == 0 || result.RequeueAfter > pollInterval(webAppCrd)) {
+				result.RequeueAfter = pollInterval(webAppCrd)
+			}
+		}()
+	}
+
+	versionToDeploy := webAppCrd.Spec.VersionToDeploy
 	deploymentParameters := deploy.Parameters{
 		AzureCredential: &deploy.AzureCredential{
 			TenantId:  &webAppCrd.Spec.AzureTenantId,
@@ -60,59 +146,401 @@ func (r *WebappReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctr
 		ContainerName:   &webAppCrd.Spec.ContainerName,
 		FileNameToCheck: &webAppCrd.Spec.FileNameToCheck,
 		BlobTagKey:      &webAppCrd.Spec.BlobTagKey,
-		VersionToDeploy: &webAppCrd.Spec.VersionToDeploy,
+		VersionToDeploy: &versionToDeploy,
 		Package: &deploy.Package{
 			StorageName:   &webAppCrd.Spec.PackageStorageName,
 			ContainerName: &webAppCrd.Spec.PackageContainerName,
 		},
+		VersionCache: r.versions.of(req.NamespacedName),
+		Dependencies: r.Dependencies,
+	}
+	deploymentParameters = withVersionMarker(deploymentParameters, webAppCrd)
+	deploymentParameters = withStorageAccess(deploymentParameters, webAppCrd, r.Endpoint)
+
+	rollback := rollbackTrigger(webAppCrd)
+	if rollback != "" {
+		versionToDeploy, err = resolveRollback(webAppCrd, rollback)
+		if err != nil {
+			logger.Error(err, "Unable to rollback", "rollbackTo", rollback)
+			webAppCrd.Status.Status = "ERROR"
+			meta.SetStatusCondition(&webAppCrd.Status.Conditions, v1.Condition{
+				Type:    conditionDegraded,
+				Status:  v1.ConditionTrue,
+				Reason:  reasonRollbackFailed,
+				Message: err.Error(),
+			})
+			r.Recorder.Event(webAppCrd, corev1.EventTypeWarning, reasonRollbackFailed, err.Error())
+			// Retrying will not help until the trigger is fixed, which will enqueue a new reconciliation
+			return ctrl.Result{}, r.Status().Update(ctx, webAppCrd)
+		}
+		logger.Info("Rolling back", "rollbackTo", rollback, "version", versionToDeploy)
+	} else if tracksLatestVersion(webAppCrd) {
+		versionToDeploy, err = r.resolveLatestVersion(ctx, webAppCrd, deploymentParameters)
+		if err != nil {
+			if errStatusUpdate := r.Status().Update(ctx, webAppCrd); errStatusUpdate != nil {
+				return ctrl.Result{}, errStatusUpdate
+			}
+			return ctrl.Result{}, err
+		}
+	}
+
+	if versionToDeploy == "" {
+		logger.Info("No version to deploy")
+		webAppCrd.Status.Status = "ERROR"
+		meta.SetStatusCondition(&webAppCrd.Status.Conditions, v1.Condition{
+			Type:    conditionDegraded,
+			Status:  v1.ConditionTrue,
+			Reason:  reasonMissingVersion,
+			Message: "versionToDeploy is required unless versionPolicy.mode is Latest",
+		})
+		return ctrl.Result{}, r.Status().Update(ctx, webAppCrd)
+	}
+
+	span.SetAttributes(attribute.String("version", versionToDeploy))
+	logger = logger.WithValues("version", versionToDeploy, "storage", webAppCrd.Spec.StorageName)
+	ctx = log.IntoContext(ctx, logger)
+
+	if dryRunRequested(webAppCrd) {
+		return r.planDeployment(ctx, webAppCrd, deploymentParameters)
+	}
+
+	if rollback == "" && !approved(webAppCrd, versionToDeploy) {
+		return r.awaitApproval(ctx, webAppCrd, deploymentParameters)
+	}
+
+	if rollback == "" && versionToDeploy != webAppCrd.Status.DeployedVersion {
+		freezes, err := r.freezePeriods(ctx)
+		if err != nil && !errors.Is(err, errInvalidFreeze) {
+			return ctrl.Result{}, err
+		}
+		invalidReason := reasonInvalidFreeze
+		now := time.Now()
+		var next time.Time
+		var reason string
+		if err == nil {
+			invalidReason = reasonInvalidWindows
+			next, reason, err = nextDeploymentTime(webAppCrd.Spec.DeploymentWindows, freezes, now)
+		}
+		if err != nil {
+			logger.Error(err, "Invalid deployment schedule")
+			webAppCrd.Status.Status = "ERROR"
+			meta.SetStatusCondition(&webAppCrd.Status.Conditions, v1.Condition{
+				Type:    conditionDegraded,
+				Status:  v1.ConditionTrue,
+				Reason:  invalidReason,
+				Message: err.Error(),
+			})
+			r.Recorder.Event(webAppCrd, corev1.EventTypeWarning, invalidReason, err.Error())
+			// Retrying will not help until the windows or the freezes are fixed, which will enqueue a new reconciliation
+			return ctrl.Result{}, r.Status().Update(ctx, webAppCrd)
+		}
+		if reason != "" {
+			logger.Info("Deployment waiting for the next window", "reason", reason, "next", next)
+			webAppCrd.Status.Status = "WAITING"
+			meta.SetStatusCondition(&webAppCrd.Status.Conditions, v1.Condition{
+				Type:    conditionWaitingForWindow,
+				Status:  v1.ConditionTrue,
+				Reason:  reasonOutsideWindow,
+				Message: fmt.Sprintf("%s, version %s will be deployed at %s", reason, versionToDeploy, next.Format(time.RFC3339)),
+			})
+			return ctrl.Result{RequeueAfter: next.Sub(now) + time.Second}, r.Status().Update(ctx, webAppCrd)
+		}
+	}
+	if meta.IsStatusConditionTrue(webAppCrd.Status.Conditions, conditionWaitingForWindow) {
+		meta.SetStatusCondition(&webAppCrd.Status.Conditions, v1.Condition{
+			Type:   conditionWaitingForWindow,
+			Status: v1.ConditionFalse,
+			Reason: reasonInWindow,
+		})
 	}
 
-	fmt.Println(deploymentParameters)
+	targets := deploymentTargets(webAppCrd)
+	for _, target := range targets {
+		owner, err := r.targetOwner(ctx, webAppCrd, target)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if owner == req.NamespacedName {
+			continue
+		}
+		logger.Info("Target is claimed by another Webapp, not deploying", "target", target, "owner", owner)
+		message := fmt.Sprintf("Container %s is already deployed by Webapp %s", target, owner)
+		if !meta.IsStatusConditionPresentAndEqual(webAppCrd.Status.Conditions, conditionTargetConflict, v1.ConditionTrue) {
+			r.Recorder.Event(webAppCrd, corev1.EventTypeWarning, conditionTargetConflict, message)
+		}
+		meta.SetStatusCondition(&webAppCrd.Status.Conditions, v1.Condition{
+			Type:    conditionTargetConflict,
+			Status:  v1.ConditionTrue,
+			Reason:  reasonTargetClaimed,
+			Message: message,
+		})
+		// The Webapp is enqueued again when the owner of the target is deleted or changes its target
+		return ctrl.Result{}, r.Status().Update(ctx, webAppCrd)
+	}
 
-	err = deploy.StartDeployment(deploymentParameters)
+	for i, target := range targets {
+		if holder, locked := r.locks.tryLock(target, req.NamespacedName); !locked {
+			for _, locked := range targets[:i] {
+				r.locks.unlock(locked, req.NamespacedName)
+			}
+			logger.Info("Target is being deployed by another Webapp, waiting", "target", target, "holder", holder)
+			meta.SetStatusCondition(&webAppCrd.Status.Conditions, v1.Condition{
+				Type:    conditionTargetConflict,
+				Status:  v1.ConditionTrue,
+				Reason:  reasonTargetLocked,
+				Message: fmt.Sprintf("Container %s is being deployed by Webapp %s", target, holder),
+			})
+			return ctrl.Result{RequeueAfter: targetLockedRequeueDelay}, r.Status().Update(ctx, webAppCrd)
+		}
+		defer r.locks.unlock(target, req.NamespacedName)
+	}
+	meta.RemoveStatusCondition(&webAppCrd.Status.Conditions, conditionTargetConflict)
+	if len(webAppCrd.Spec.Targets) == 0 {
+		// The targets removed from the spec are not deployed anymore
+		webAppCrd.Status.Targets = nil
+	}
+
+	deployCtx, cancel := context.WithTimeout(ctx, deploymentTimeout(webAppCrd, r.DeploymentTimeout))
+	defer cancel()
+
+	start := time.Now()
+	err = r.deploy(deployCtx, webAppCrd, deploymentParameters, deploy.Observers{
+		eventObserver{recorder: r.Recorder, webapp: webAppCrd},
+		metricsObserver{webapp: req.NamespacedName},
+	})
 
 	var condition v1.Condition
+	var rollbackRevision int64
+	progressing := v1.Condition{
+		Type:   conditionProgressing,
+		Status: v1.ConditionFalse,
+		Reason: reasonDeployed,
+	}
 
-	dateNow := time.Now().Format(time.Layout)
 	if err != nil {
-		log.Log.Info(fmt.Sprintf("Fail to reconcile (%s) %s - %s", dateNow, req.Name, err))
+		logger.Error(err, "Deployment failed", "duration", time.Since(start))
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		recordDeploymentMetrics(req.NamespacedName, outcomeFailure, time.Since(start))
 		webAppCrd.Status.Status = "ERROR"
+		r.Recorder.Eventf(webAppCrd, corev1.EventTypeWarning, reasonDeploymentFailed, "Unable to deploy version %s: %v", versionToDeploy, err)
 		condition = v1.Condition{
-			Type:    "Degraded",
-			Status:  v1.ConditionFalse,
-			Reason:  "DeploymentFailed",
-			Message: "",
+			Type:    conditionDegraded,
+			Status:  v1.ConditionTrue,
+			Reason:  reasonDeploymentFailed,
+			Message: err.Error(),
+		}
+		progressing.Reason = interruptionReason(deployCtx)
+		progressing.Message = err.Error()
+
+		var verificationError *deploy.VerificationError
+		if errors.As(err, &verificationError) {
+			condition.Reason = reasonVerificationFailed
+			progressing.Reason = reasonVerificationFailed
+			if rollback == "" {
+				rollbackRevision = verificationRollbackRevision(webAppCrd)
+			}
 		}
 	} else {
-		log.Log.Info(fmt.Sprintf("Reconcile is ok (%s) %s", dateNow, req.Name))
+		logger.Info("Deployment succeeded", "duration", time.Since(start))
 		webAppCrd.Status.Status = "SUCCESS"
-		webAppCrd.Status.DeployedVersion = webAppCrd.Spec.VersionToDeploy
+		if webAppCrd.Status.DeployedVersion != versionToDeploy || len(webAppCrd.Status.History) == 0 {
+			recordDeployment(&webAppCrd.Status, versionToDeploy, v1.Now())
+			recordDeploymentMetrics(req.NamespacedName, outcomeSuccess, time.Since(start))
+			r.Recorder.Eventf(webAppCrd, corev1.EventTypeNormal, "Deployed", "Version %s deployed", versionToDeploy)
+		}
+		webAppCrd.Status.DeployedVersion = versionToDeploy
+		setDeployedVersionMetric(req.NamespacedName, versionToDeploy)
 		condition = v1.Condition{
-			Type:    "Available",
+			Type:    conditionAvailable,
 			Status:  v1.ConditionTrue,
-			Reason:  "Deployed",
+			Reason:  reasonDeployed,
 			Message: "",
 		}
+		if meta.IsStatusConditionTrue(webAppCrd.Status.Conditions, conditionPendingApproval) {
+			meta.SetStatusCondition(&webAppCrd.Status.Conditions, v1.Condition{
+				Type:    conditionPendingApproval,
+				Status:  v1.ConditionFalse,
+				Reason:  reasonApproved,
+				Message: fmt.Sprintf("Version %s approved and deployed", versionToDeploy),
+			})
+		}
+		if rollback != "" {
+			condition.Reason = reasonRolledBack
+			condition.Message = fmt.Sprintf("Rolled back to version %s", versionToDeploy)
+			progressing.Reason = reasonRolledBack
+			r.Recorder.Event(webAppCrd, corev1.EventTypeNormal, reasonRolledBack, condition.Message)
+		}
 	}
 
+	// Available and Degraded always tell the outcome of the last deployment together
+	counterpart := v1.Condition{
+		Type:    conditionDegraded,
+		Status:  v1.ConditionFalse,
+		Reason:  condition.Reason,
+		Message: condition.Message,
+	}
+	if condition.Type == conditionDegraded {
+		counterpart.Type = conditionAvailable
+	}
 	meta.SetStatusCondition(&webAppCrd.Status.Conditions, condition)
-	errStatusUpdate := r.Status().Update(ctx, webAppCrd)
+	meta.SetStatusCondition(&webAppCrd.Status.Conditions, counterpart)
+	meta.SetStatusCondition(&webAppCrd.Status.Conditions, progressing)
+
+	statusCtx := ctx
+	if ctx.Err() != nil {
+		// The manager is stopping, the status must still tell why the deployment was interrupted
+		var cancelStatus context.CancelFunc
+		statusCtx, cancelStatus = context.WithTimeout(context.Background(), statusUpdateTimeout)
+		defer cancelStatus()
+	}
+	errStatusUpdate := r.Status().Update(statusCtx, webAppCrd)
 
 	if errStatusUpdate != nil {
-		return ctrl.Result{}, err
+		return ctrl.Result{}, errStatusUpdate
+	}
+
+	if err == nil && rollback != "" {
+		// A patch does not conflict with the status update nor with edits made during the deployment
+		patch := client.MergeFrom(webAppCrd.DeepCopy())
+		clearRollbackTrigger(webAppCrd, versionToDeploy)
+		if errUpdate := r.Patch(ctx, webAppCrd, patch); errUpdate != nil {
+			return ctrl.Result{}, errUpdate
+		}
+	}
+
+	if rollbackRevision != 0 {
+		logger.Info("Verification failed, rolling back", "revision", rollbackRevision)
+		r.Recorder.Eventf(webAppCrd, corev1.EventTypeWarning, reasonVerificationFailed, "Version %s failed its verification, rolling back to revision %d", versionToDeploy, rollbackRevision)
+		patch := client.MergeFrom(webAppCrd.DeepCopy())
+		webAppCrd.Spec.RollbackTo = strconv.FormatInt(rollbackRevision, 10)
+		if errUpdate := r.Patch(ctx, webAppCrd, patch); errUpdate != nil {
+			return ctrl.Result{}, errUpdate
+		}
+		// The update enqueues the rollback
+		return ctrl.Result{}, nil
 	}
 
 	if err != nil {
-		log.Log.Info("Ending reconciliation")
 		return ctrl.Result{}, err
 	}
 
 	return ctrl.Result{}, nil
 }
 
+// planDeployment computes the deployment plan into the status, nothing is uploaded to the target
+func (r *WebappReconciler) planDeployment(ctx context.Context, webapp *webappv1alpha1.Webapp, deploymentParameters deploy.Parameters) (ctrl.Result, error) {
+	message, err := r.computePlan(ctx, webapp, deploymentParameters)
+	if err != nil {
+		if errStatusUpdate := r.Status().Update(ctx, webapp); errStatusUpdate != nil {
+			return ctrl.Result{}, errStatusUpdate
+		}
+		return ctrl.Result{}, err
+	}
+
+	webapp.Status.Status = "PLANNED"
+	meta.SetStatusCondition(&webapp.Status.Conditions, v1.Condition{
+		Type:    conditionProgressing,
+		Status:  v1.ConditionFalse,
+		Reason:  reasonPlanned,
+		Message: message,
+	})
+	return ctrl.Result{}, r.Status().Update(ctx, webapp)
+}
+
+// computePlan writes the deployment plan into the Webapp status and returns its summary.
+// On failure the status is marked as degraded, the caller is in charge of updating it.
+func (r *WebappReconciler) computePlan(ctx context.Context, webapp *webappv1alpha1.Webapp, deploymentParameters deploy.Parameters) (string, error) {
+	logger := log.FromContext(ctx)
+	version := *deploymentParameters.VersionToDeploy
+
+	previews, err := r.previewPrefixes(ctx, webapp)
+	if err != nil {
+		return "", err
+	}
+	deploymentParameters.ExcludedPrefixes = previews
+	if webapp.Spec.BlueGreen != nil {
+		// The plan is computed against the slot receiving the version
+		deploymentParameters = slotParameters(deploymentParameters, webapp.Spec.BlueGreen, idleSlot(webapp.Status.ActiveSlot))
+	}
+
+	planCtx, cancel := context.WithTimeout(ctx, deploymentTimeout(webapp, r.DeploymentTimeout))
+	defer cancel()
+
+	observer := deploy.Observers{
+		eventObserver{recorder: r.Recorder, webapp: webapp},
+		metricsObserver{webapp: client.ObjectKeyFromObject(webapp)},
+	}
+	var plan *deploy.Plan
+	if len(webapp.Spec.Targets) > 0 {
+		plan, err = planTargets(planCtx, webapp, deploymentParameters, observer)
+	} else {
+		plan, err = deploy.PlanDeployment(planCtx, deploymentParameters, observer)
+	}
+	if err != nil {
+		logger.Error(err, "Unable to plan the deployment")
+		webapp.Status.Status = "ERROR"
+		r.Recorder.Eventf(webapp, corev1.EventTypeWarning, reasonPlanFailed, "Unable to plan the deployment of version %s: %v", version, err)
+		meta.SetStatusCondition(&webapp.Status.Conditions, v1.Condition{
+			Type:    conditionDegraded,
+			Status:  v1.ConditionTrue,
+			Reason:  reasonPlanFailed,
+			Message: err.Error(),
+		})
+		return "", err
+	}
+
+	webapp.Status.Plan = planStatus(plan, version, v1.Now())
+	message := fmt.Sprintf("Deploying version %s would add %d and change %d files, %d stale files would be left in place",
+		version, webapp.Status.Plan.Added, webapp.Status.Plan.Changed, webapp.Status.Plan.Stale)
+	logger.Info("Plan computed", "added", webapp.Status.Plan.Added, "changed", webapp.Status.Plan.Changed, "stale", webapp.Status.Plan.Stale)
+	r.Recorder.Event(webapp, corev1.EventTypeNormal, reasonPlanned, message)
+	return message, nil
+}
+
+// deploymentTimeout returns the timeout of the Webapp deployment, falling back on the reconciler default
+func deploymentTimeout(webapp *webappv1alpha1.Webapp, defaultTimeout time.Duration) time.Duration {
+	if webapp.Spec.DeploymentTimeout != nil && webapp.Spec.DeploymentTimeout.Duration > 0 {
+		return webapp.Spec.DeploymentTimeout.Duration
+	}
+	if defaultTimeout > 0 {
+		return defaultTimeout
+	}
+	return DefaultDeploymentTimeout
+}
+
+// interruptionReason tells whether a failed deployment was interrupted by its timeout or by the manager stopping
+func interruptionReason(deployCtx context.Context) string {
+	switch deployCtx.Err() {
+	case context.DeadlineExceeded:
+		return reasonTimeout
+	case context.Canceled:
+		return reasonCancelled
+	default:
+		return reasonDeploymentFailed
+	}
+}
+
 func (r *WebappReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
+	err := mgr.GetFieldIndexer().IndexField(context.Background(), &webappv1alpha1.Webapp{}, targetIndexKey, indexWebappTarget)
+	if err != nil {
+		return err
+	}
+	err = mgr.GetFieldIndexer().IndexField(context.Background(), &webappv1alpha1.Webapp{}, packageIndexKey, indexWebappPackage)
+	if err != nil {
+		return err
+	}
+
+	builder := ctrl.NewControllerManagedBy(mgr).
 		For(&webappv1alpha1.Webapp{}).
-		WithOptions(controller.Options{MaxConcurrentReconciles: 1}).
-		Complete(r)
+		Watches(&source.Kind{Type: &webappv1alpha1.Webapp{}}, handler.EnqueueRequestsFromMapFunc(r.webappsSharingTarget)).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles})
+	if r.Triggers != nil {
+		builder = builder.Watches(&source.Channel{Source: r.Triggers}, &handler.EnqueueRequestForObject{})
+	}
+	if r.FreezeConfigMap.Name != "" {
+		// ConfigMaps are only watched when a freeze ConfigMap is configured, the manager cache is restricted to it
+		builder = builder.Watches(&source.Kind{Type: &corev1.ConfigMap{}}, handler.EnqueueRequestsFromMapFunc(r.webappsWaitingForWindow))
+	}
+	return builder.Complete(r)
 }
@@ -0,0 +1,423 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	webappv1alpha1 "github.com/morganleroi/deploy-website-k8s-operator/api/v1alpha1"
+	"github.com/morganleroi/deploy-website-k8s-operator/controllers/deploy/deploytest"
+)
+
+const (
+	eventuallyTimeout  = 20 * time.Second
+	eventuallyInterval = 250 * time.Millisecond
+)
+
+// sitePackage returns a package whose files carry the version
+func sitePackage(version string) []byte {
+	return deploytest.Package(map[string]string{
+		"index.html":  fmt.Sprintf("<html><head><title>%s</title></head></html>", version),
+		"app/main.js": fmt.Sprintf("console.log('%s')", version),
+	})
+}
+
+// newWebapp returns a Webapp deploying to its own container of the blob server
+func newWebapp(name string, version string) *webappv1alpha1.Webapp {
+	return &webappv1alpha1.Webapp{
+		ObjectMeta: v1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: webappv1alpha1.WebappSpec{
+			StorageName:                   deploytest.AccountName,
+			ContainerName:                 name,
+			FileNameToCheck:               "index.html",
+			BlobTagKey:                    "version",
+			VersionToDeploy:               version,
+			PackageStorageName:            deploytest.AccountName,
+			PackageContainerName:          "packages",
+			AzureStorageAccountKey:        deploytest.AccountKey,
+			AzurePackageStorageAccountKey: deploytest.AccountKey,
+			Endpoint: &webappv1alpha1.StorageEndpoint{
+				BlobEndpoint: blobServer.URL,
+				PathStyle:    true,
+			},
+		},
+	}
+}
+
+func getWebapp(name string) func() (*webappv1alpha1.Webapp, error) {
+	return func() (*webappv1alpha1.Webapp, error) {
+		webapp := &webappv1alpha1.Webapp{}
+		err := k8sClient.Get(context.Background(), types.NamespacedName{Name: name, Namespace: "default"}, webapp)
+		return webapp, err
+	}
+}
+
+func deployedVersion(name string) func() string {
+	return func() string {
+		webapp, err := getWebapp(name)()
+		if err != nil || webapp.Status.Status != "SUCCESS" {
+			return ""
+		}
+		return webapp.Status.DeployedVersion
+	}
+}
+
+func degradedReason(name string) func() string {
+	return conditionReason(name, conditionDegraded)
+}
+
+func conditionReason(name string, conditionType string) func() string {
+	return func() string {
+		webapp, err := getWebapp(name)()
+		if err != nil {
+			return ""
+		}
+		condition := meta.FindStatusCondition(webapp.Status.Conditions, conditionType)
+		if condition == nil || condition.Status != v1.ConditionTrue {
+			return ""
+		}
+		return condition.Reason
+	}
+}
+
+func setVersion(name string, version string) {
+	updateSpec(name, func(spec *webappv1alpha1.WebappSpec) {
+		spec.VersionToDeploy = version
+	})
+}
+
+func updateSpec(name string, update func(spec *webappv1alpha1.WebappSpec)) {
+	Eventually(func() error {
+		webapp, err := getWebapp(name)()
+		if err != nil {
+			return err
+		}
+		update(&webapp.Spec)
+		return k8sClient.Update(context.Background(), webapp)
+	}, eventuallyTimeout, eventuallyInterval).Should(Succeed())
+}
+
+var _ = Describe("Webapp controller", func() {
+	BeforeEach(func() {
+		for _, version := range []string{"v1.0.0", "v1.1.0"} {
+			blobServer.PutBlob(deploytest.AccountName, "packages", version+".zip", sitePackage(version), nil)
+		}
+		blobServer.PutBlob(deploytest.AccountName, "packages", "corrupt.zip", []byte("not a zip file"), nil)
+	})
+
+	It("deploys the first version to an empty container", func() {
+		Expect(k8sClient.Create(context.Background(), newWebapp("first-deploy", "v1.0.0"))).To(Succeed())
+
+		Eventually(deployedVersion("first-deploy"), eventuallyTimeout, eventuallyInterval).Should(Equal("v1.0.0"))
+		Expect(blobServer.BlobNames(deploytest.AccountName, "first-deploy")).To(ConsistOf("index.html", "app/main.js"))
+		index, _ := blobServer.GetBlob(deploytest.AccountName, "first-deploy", "index.html")
+		Expect(index.Tags).To(HaveKeyWithValue("version", "v1.0.0"))
+
+		webapp, err := getWebapp("first-deploy")()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(meta.IsStatusConditionTrue(webapp.Status.Conditions, conditionAvailable)).To(BeTrue())
+		Expect(meta.IsStatusConditionFalse(webapp.Status.Conditions, conditionProgressing)).To(BeTrue())
+		Expect(webapp.Status.History).To(HaveLen(1))
+		Expect(webapp.Status.History[0].Version).To(Equal("v1.0.0"))
+	})
+
+	It("does not upload anything when the version is already deployed", func() {
+		blobServer.PutBlob(deploytest.AccountName, "already-deployed", "index.html", []byte("v1.0.0"), map[string]string{"version": "v1.0.0"})
+		Expect(k8sClient.Create(context.Background(), newWebapp("already-deployed", "v1.0.0"))).To(Succeed())
+
+		Eventually(deployedVersion("already-deployed"), eventuallyTimeout, eventuallyInterval).Should(Equal("v1.0.0"))
+		Expect(blobServer.BlobNames(deploytest.AccountName, "already-deployed")).To(ConsistOf("index.html"))
+		index, _ := blobServer.GetBlob(deploytest.AccountName, "already-deployed", "index.html")
+		Expect(string(index.Content)).To(Equal("v1.0.0"))
+	})
+
+	It("upgrades the deployed version", func() {
+		Expect(k8sClient.Create(context.Background(), newWebapp("upgrade", "v1.0.0"))).To(Succeed())
+		Eventually(deployedVersion("upgrade"), eventuallyTimeout, eventuallyInterval).Should(Equal("v1.0.0"))
+
+		setVersion("upgrade", "v1.1.0")
+
+		Eventually(deployedVersion("upgrade"), eventuallyTimeout, eventuallyInterval).Should(Equal("v1.1.0"))
+		index, _ := blobServer.GetBlob(deploytest.AccountName, "upgrade", "index.html")
+		Expect(index.Tags).To(HaveKeyWithValue("version", "v1.1.0"))
+		Expect(string(index.Content)).To(ContainSubstring("v1.1.0"))
+
+		webapp, err := getWebapp("upgrade")()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(webapp.Status.History).To(HaveLen(2))
+		Expect(webapp.Status.History[1].Revision).To(Equal(int64(2)))
+	})
+
+	It("rolls back to a revision of the history and clears the trigger", func() {
+		Expect(k8sClient.Create(context.Background(), newWebapp("rollback", "v1.0.0"))).To(Succeed())
+		Eventually(deployedVersion("rollback"), eventuallyTimeout, eventuallyInterval).Should(Equal("v1.0.0"))
+		setVersion("rollback", "v1.1.0")
+		Eventually(deployedVersion("rollback"), eventuallyTimeout, eventuallyInterval).Should(Equal("v1.1.0"))
+
+		updateSpec("rollback", func(spec *webappv1alpha1.WebappSpec) {
+			spec.RollbackTo = "42"
+		})
+		Eventually(degradedReason("rollback"), eventuallyTimeout, eventuallyInterval).Should(Equal(reasonRollbackFailed))
+		webapp, err := getWebapp("rollback")()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(webapp.Status.DeployedVersion).To(Equal("v1.1.0"))
+
+		updateSpec("rollback", func(spec *webappv1alpha1.WebappSpec) {
+			spec.RollbackTo = webappv1alpha1.RollbackPrevious
+		})
+		Eventually(func() string {
+			webapp, err := getWebapp("rollback")()
+			if err != nil || webapp.Spec.RollbackTo != "" {
+				return ""
+			}
+			return webapp.Spec.VersionToDeploy
+		}, eventuallyTimeout, eventuallyInterval).Should(Equal("v1.0.0"))
+		Eventually(deployedVersion("rollback"), eventuallyTimeout, eventuallyInterval).Should(Equal("v1.0.0"))
+		webapp, err = getWebapp("rollback")()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(webapp.Status.History).To(HaveLen(3))
+		Expect(webapp.Status.History[2].Version).To(Equal("v1.0.0"))
+		index, _ := blobServer.GetBlob(deploytest.AccountName, "rollback", "index.html")
+		Expect(index.Tags).To(HaveKeyWithValue("version", "v1.0.0"))
+	})
+
+	It("does not deploy a suspended Webapp until it is resumed", func() {
+		Expect(k8sClient.Create(context.Background(), newWebapp("suspend", "v1.0.0"))).To(Succeed())
+		Eventually(deployedVersion("suspend"), eventuallyTimeout, eventuallyInterval).Should(Equal("v1.0.0"))
+
+		Eventually(func() error {
+			webapp, err := getWebapp("suspend")()
+			if err != nil {
+				return err
+			}
+			webapp.Spec.Suspend = true
+			return k8sClient.Update(context.Background(), webapp)
+		}, eventuallyTimeout, eventuallyInterval).Should(Succeed())
+		Eventually(func() bool {
+			webapp, err := getWebapp("suspend")()
+			return err == nil && meta.IsStatusConditionTrue(webapp.Status.Conditions, conditionSuspended)
+		}, eventuallyTimeout, eventuallyInterval).Should(BeTrue())
+
+		setVersion("suspend", "v1.1.0")
+		Consistently(deployedVersion("suspend"), 2*time.Second, eventuallyInterval).Should(Equal("v1.0.0"))
+
+		Eventually(func() error {
+			webapp, err := getWebapp("suspend")()
+			if err != nil {
+				return err
+			}
+			webapp.Spec.Suspend = false
+			return k8sClient.Update(context.Background(), webapp)
+		}, eventuallyTimeout, eventuallyInterval).Should(Succeed())
+		Eventually(deployedVersion("suspend"), eventuallyTimeout, eventuallyInterval).Should(Equal("v1.1.0"))
+
+		webapp, err := getWebapp("suspend")()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(meta.IsStatusConditionFalse(webapp.Status.Conditions, conditionSuspended)).To(BeTrue())
+	})
+
+	It("deploys the directories of the package to their targets", func() {
+		blobServer.PutBlob(deploytest.AccountName, "packages", "v2.0.0.zip", deploytest.Package(map[string]string{
+			"shell/index.html":    "<html><head><title>shell</title></head></html>",
+			"checkout/index.html": "<html><head><title>checkout</title></head></html>",
+		}), nil)
+		webapp := newWebapp("targets", "v2.0.0")
+		webapp.Spec.Targets = []webappv1alpha1.Target{
+			{Name: "shell", Path: "shell"},
+			{Name: "checkout", Path: "checkout", ContainerName: "targets-checkout", Prefix: "checkout"},
+		}
+		Expect(k8sClient.Create(context.Background(), webapp)).To(Succeed())
+
+		Eventually(deployedVersion("targets"), eventuallyTimeout, eventuallyInterval).Should(Equal("v2.0.0"))
+		Expect(blobServer.BlobNames(deploytest.AccountName, "targets")).To(ConsistOf("index.html"))
+		Expect(blobServer.BlobNames(deploytest.AccountName, "targets-checkout")).To(ConsistOf("checkout/index.html"))
+
+		webapp, err := getWebapp("targets")()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(webapp.Status.Targets).To(HaveLen(2))
+		for _, target := range webapp.Status.Targets {
+			Expect(target.Status).To(Equal("SUCCESS"))
+			Expect(target.DeployedVersion).To(Equal("v2.0.0"))
+		}
+
+		conflicting := newWebapp("targets-conflict", "v1.0.0")
+		conflicting.Spec.ContainerName = "targets-checkout"
+		Expect(k8sClient.Create(context.Background(), conflicting)).To(Succeed())
+		Eventually(conditionReason("targets-conflict", conditionTargetConflict), eventuallyTimeout, eventuallyInterval).Should(Equal(reasonTargetClaimed))
+		Expect(blobServer.BlobNames(deploytest.AccountName, "targets-checkout")).To(ConsistOf("checkout/index.html"))
+
+		Eventually(func() error {
+			webapp, err := getWebapp("targets")()
+			if err != nil {
+				return err
+			}
+			webapp.Spec.Targets = nil
+			webapp.Spec.VersionToDeploy = "v1.0.0"
+			return k8sClient.Update(context.Background(), webapp)
+		}, eventuallyTimeout, eventuallyInterval).Should(Succeed())
+		Eventually(func() int {
+			webapp, err := getWebapp("targets")()
+			if err != nil || webapp.Status.DeployedVersion != "v1.0.0" {
+				return -1
+			}
+			return len(webapp.Status.Targets)
+		}, eventuallyTimeout, eventuallyInterval).Should(Equal(0))
+	})
+
+	It("deploys a Webapp sharing a container once the owner of the container is deleted", func() {
+		Expect(k8sClient.Create(context.Background(), newWebapp("owner", "v1.0.0"))).To(Succeed())
+		Eventually(deployedVersion("owner"), eventuallyTimeout, eventuallyInterval).Should(Equal("v1.0.0"))
+
+		contender := newWebapp("contender", "v1.1.0")
+		contender.Spec.ContainerName = "owner"
+		Expect(k8sClient.Create(context.Background(), contender)).To(Succeed())
+		Eventually(conditionReason("contender", conditionTargetConflict), eventuallyTimeout, eventuallyInterval).Should(Equal(reasonTargetClaimed))
+		Consistently(deployedVersion("contender"), time.Second, eventuallyInterval).Should(BeEmpty())
+		index, _ := blobServer.GetBlob(deploytest.AccountName, "owner", "index.html")
+		Expect(index.Tags).To(HaveKeyWithValue("version", "v1.0.0"))
+
+		owner, err := getWebapp("owner")()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(k8sClient.Delete(context.Background(), owner)).To(Succeed())
+		Eventually(func() bool {
+			_, err := getWebapp("owner")()
+			return apierrors.IsNotFound(err)
+		}, eventuallyTimeout, eventuallyInterval).Should(BeTrue())
+
+		// The contender is enqueued by the deletion of the owner and takes the container over
+		Eventually(deployedVersion("contender"), eventuallyTimeout, eventuallyInterval).Should(Equal("v1.1.0"))
+		Expect(conditionReason("contender", conditionTargetConflict)()).To(BeEmpty())
+		index, _ = blobServer.GetBlob(deploytest.AccountName, "owner", "index.html")
+		Expect(index.Tags).To(HaveKeyWithValue("version", "v1.1.0"))
+	})
+
+	It("clears the degraded condition once a deployment succeeds after a failure", func() {
+		Expect(k8sClient.Create(context.Background(), newWebapp("recovery", "v9.9.9"))).To(Succeed())
+		Eventually(degradedReason("recovery"), eventuallyTimeout, eventuallyInterval).Should(Equal(reasonDeploymentFailed))
+		webapp, err := getWebapp("recovery")()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(meta.IsStatusConditionFalse(webapp.Status.Conditions, conditionAvailable)).To(BeTrue())
+
+		setVersion("recovery", "v1.0.0")
+
+		Eventually(deployedVersion("recovery"), eventuallyTimeout, eventuallyInterval).Should(Equal("v1.0.0"))
+		webapp, err = getWebapp("recovery")()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(meta.IsStatusConditionTrue(webapp.Status.Conditions, conditionAvailable)).To(BeTrue())
+		Expect(meta.IsStatusConditionFalse(webapp.Status.Conditions, conditionDegraded)).To(BeTrue())
+	})
+
+	It("deletes the previews and their files before the Webapp", func() {
+		Expect(k8sClient.Create(context.Background(), newWebapp("previewed", "v1.0.0"))).To(Succeed())
+		Eventually(deployedVersion("previewed"), eventuallyTimeout, eventuallyInterval).Should(Equal("v1.0.0"))
+
+		preview := &webappv1alpha1.WebappPreview{
+			ObjectMeta: v1.ObjectMeta{Name: "next", Namespace: "default"},
+			Spec:       webappv1alpha1.WebappPreviewSpec{WebappName: "previewed", Version: "v1.1.0"},
+		}
+		Expect(k8sClient.Create(context.Background(), preview)).To(Succeed())
+		Eventually(func() []string {
+			return blobServer.BlobNames(deploytest.AccountName, "previewed")
+		}, eventuallyTimeout, eventuallyInterval).Should(ContainElement("previews/next/index.html"))
+
+		webapp, err := getWebapp("previewed")()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(k8sClient.Delete(context.Background(), webapp)).To(Succeed())
+		Eventually(func() bool {
+			_, err := getWebapp("previewed")()
+			return apierrors.IsNotFound(err)
+		}, eventuallyTimeout, eventuallyInterval).Should(BeTrue())
+		Expect(blobServer.BlobNames(deploytest.AccountName, "previewed")).To(ConsistOf("index.html", "app/main.js"))
+	})
+
+	It("suspends the previews of a suspended Webapp and deletes them with it", func() {
+		Expect(k8sClient.Create(context.Background(), newWebapp("suspended-preview", "v1.0.0"))).To(Succeed())
+		Eventually(deployedVersion("suspended-preview"), eventuallyTimeout, eventuallyInterval).Should(Equal("v1.0.0"))
+		preview := &webappv1alpha1.WebappPreview{
+			ObjectMeta: v1.ObjectMeta{Name: "kept", Namespace: "default"},
+			Spec:       webappv1alpha1.WebappPreviewSpec{WebappName: "suspended-preview", Version: "v1.1.0"},
+		}
+		Expect(k8sClient.Create(context.Background(), preview)).To(Succeed())
+		Eventually(func() []string {
+			return blobServer.BlobNames(deploytest.AccountName, "suspended-preview")
+		}, eventuallyTimeout, eventuallyInterval).Should(ContainElement("previews/kept/index.html"))
+
+		Eventually(func() error {
+			webapp, err := getWebapp("suspended-preview")()
+			if err != nil {
+				return err
+			}
+			webapp.Spec.Suspend = true
+			return k8sClient.Update(context.Background(), webapp)
+		}, eventuallyTimeout, eventuallyInterval).Should(Succeed())
+		Eventually(func() bool {
+			preview := &webappv1alpha1.WebappPreview{}
+			err := k8sClient.Get(context.Background(), types.NamespacedName{Name: "kept", Namespace: "default"}, preview)
+			return err == nil && meta.IsStatusConditionTrue(preview.Status.Conditions, conditionSuspended)
+		}, eventuallyTimeout, eventuallyInterval).Should(BeTrue())
+
+		webapp, err := getWebapp("suspended-preview")()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(k8sClient.Delete(context.Background(), webapp)).To(Succeed())
+		Eventually(func() bool {
+			_, err := getWebapp("suspended-preview")()
+			return apierrors.IsNotFound(err)
+		}, eventuallyTimeout, eventuallyInterval).Should(BeTrue())
+		Expect(blobServer.BlobNames(deploytest.AccountName, "suspended-preview")).To(ConsistOf("index.html", "app/main.js"))
+	})
+
+	It("refuses a preview in a location deployed by a Webapp", func() {
+		Expect(k8sClient.Create(context.Background(), newWebapp("claimed", "v1.0.0"))).To(Succeed())
+		preview := &webappv1alpha1.WebappPreview{
+			ObjectMeta: v1.ObjectMeta{Name: "in-packages", Namespace: "default"},
+			Spec:       webappv1alpha1.WebappPreviewSpec{WebappName: "claimed", Version: "v1.1.0", ContainerName: "packages"},
+		}
+		Expect(k8sClient.Create(context.Background(), preview)).To(Succeed())
+
+		Eventually(func() string {
+			preview := &webappv1alpha1.WebappPreview{}
+			if err := k8sClient.Get(context.Background(), types.NamespacedName{Name: "in-packages", Namespace: "default"}, preview); err != nil {
+				return ""
+			}
+			condition := meta.FindStatusCondition(preview.Status.Conditions, conditionAvailable)
+			if condition == nil {
+				return ""
+			}
+			return condition.Reason
+		}, eventuallyTimeout, eventuallyInterval).Should(Equal(reasonLocationClaimed))
+		Expect(blobServer.BlobNames(deploytest.AccountName, "packages")).NotTo(ContainElement("previews/in-packages/index.html"))
+	})
+
+	It("reports a missing package", func() {
+		Expect(k8sClient.Create(context.Background(), newWebapp("missing-package", "v9.9.9"))).To(Succeed())
+
+		Eventually(degradedReason("missing-package"), eventuallyTimeout, eventuallyInterval).Should(Equal(reasonDeploymentFailed))
+		webapp, err := getWebapp("missing-package")()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(webapp.Status.Status).To(Equal("ERROR"))
+		Expect(webapp.Status.DeployedVersion).To(BeEmpty())
+		Expect(blobServer.BlobNames(deploytest.AccountName, "missing-package")).To(BeEmpty())
+	})
+
+	It("reports a corrupt package without touching the deployed version", func() {
+		Expect(k8sClient.Create(context.Background(), newWebapp("corrupt-package", "v1.0.0"))).To(Succeed())
+		Eventually(deployedVersion("corrupt-package"), eventuallyTimeout, eventuallyInterval).Should(Equal("v1.0.0"))
+
+		setVersion("corrupt-package", "corrupt")
+
+		Eventually(degradedReason("corrupt-package"), eventuallyTimeout, eventuallyInterval).Should(Equal(reasonDeploymentFailed))
+		webapp, err := getWebapp("corrupt-package")()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(webapp.Status.Status).To(Equal("ERROR"))
+		Expect(meta.FindStatusCondition(webapp.Status.Conditions, conditionDegraded).Message).To(ContainSubstring("zip"))
+		index, _ := blobServer.GetBlob(deploytest.AccountName, "corrupt-package", "index.html")
+		Expect(index.Tags).To(HaveKeyWithValue("version", "v1.0.0"))
+	})
+})
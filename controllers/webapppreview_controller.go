@@ -0,0 +1,335 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	webappv1alpha1 "github.com/morganleroi/deploy-website-k8s-operator/api/v1alpha1"
+	"github.com/morganleroi/deploy-website-k8s-operator/controllers/deploy"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+	"strings"
+	"time"
+)
+
+// WebappPreviewReconciler reconciles a WebappPreview object
+type WebappPreviewReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	// DeploymentTimeout bounds a preview deployment when its Webapp does not define its own timeout
+	DeploymentTimeout time.Duration
+	// Endpoint locates the storage accounts of the Webapps which do not define their own endpoint
+	Endpoint webappv1alpha1.StorageEndpoint
+	// SuspendAll suspends every preview, like the spec.suspend of their Webapp
+	SuspendAll bool
+	// Dependencies replaces the storage, credentials or clock of the deployments, the defaults when nil
+	Dependencies *deploy.Dependencies
+}
+
+// webappNotFoundRequeueDelay is the delay before looking for the missing Webapp of a preview again
+const webappNotFoundRequeueDelay = time.Minute
+
+//+kubebuilder:rbac:groups=webapp.simpletest.com,resources=webapppreviews,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=webapp.simpletest.com,resources=webapppreviews/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=webapp.simpletest.com,resources=webapppreviews/finalizers,verbs=update
+
+func (r *WebappPreviewReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	preview := &webappv1alpha1.WebappPreview{}
+	err := r.Get(ctx, req.NamespacedName, preview)
+	if err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	webapp := &webappv1alpha1.Webapp{}
+	err = r.Get(ctx, types.NamespacedName{Namespace: preview.Namespace, Name: preview.Spec.WebappName}, webapp)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return ctrl.Result{}, err
+	}
+	webappFound := err == nil
+
+	// A deleted preview is cleaned up even while suspended, the deletion of its Webapp waits for it
+	if !preview.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, r.cleanup(ctx, preview, webapp, webappFound)
+	}
+
+	if webappFound {
+		if reason, message := suspension(webapp, r.SuspendAll); reason != "" {
+			// Nothing is deployed nor expired, resuming the Webapp enqueues its previews again
+			return ctrl.Result{}, reportSuspended(ctx, r.Client, r.Recorder, preview, &preview.Status.Conditions, reason, message)
+		}
+	}
+	if err := reportResumed(ctx, r.Client, r.Recorder, preview, &preview.Status.Conditions); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if expiresAt := previewExpiration(preview); expiresAt != nil && !time.Now().Before(expiresAt.Time) {
+		logger.Info("Preview expired, deleting it", "expiresAt", expiresAt)
+		r.Recorder.Eventf(preview, corev1.EventTypeNormal, reasonExpired, "Preview expired at %s", expiresAt.Format(time.RFC3339))
+		return ctrl.Result{}, client.IgnoreNotFound(r.Delete(ctx, preview))
+	}
+
+	if !webappFound {
+		meta.SetStatusCondition(&preview.Status.Conditions, v1.Condition{
+			Type:    conditionAvailable,
+			Status:  v1.ConditionFalse,
+			Reason:  reasonWebappNotFound,
+			Message: fmt.Sprintf("Webapp %s not found", preview.Spec.WebappName),
+		})
+		preview.Status.Status = "ERROR"
+		// Retry later, until the Webapp is created
+		return ctrl.Result{RequeueAfter: webappNotFoundRequeueDelay}, r.Status().Update(ctx, preview)
+	}
+	if !webapp.DeletionTimestamp.IsZero() {
+		// The Webapp deletes its previews before being deleted
+		return ctrl.Result{}, nil
+	}
+
+	if !controllerutil.ContainsFinalizer(preview, webappv1alpha1.PreviewFinalizer) || !hasOwnerReference(preview, webapp) {
+		controllerutil.AddFinalizer(preview, webappv1alpha1.PreviewFinalizer)
+		if err := controllerutil.SetOwnerReference(webapp, preview, r.Scheme); err != nil {
+			return ctrl.Result{}, err
+		}
+		// The update enqueues the preview again
+		return ctrl.Result{}, r.Update(ctx, preview)
+	}
+
+	deploymentParameters, err := previewParameters(preview, webapp, r.Endpoint, r.Dependencies)
+	if err == nil {
+		err = r.checkPreviewLocation(ctx, webapp, deploymentParameters)
+	}
+	if err != nil {
+		meta.SetStatusCondition(&preview.Status.Conditions, v1.Condition{
+			Type:    conditionAvailable,
+			Status:  v1.ConditionFalse,
+			Reason:  reasonLocationClaimed,
+			Message: err.Error(),
+		})
+		preview.Status.Status = "ERROR"
+		return ctrl.Result{}, r.Status().Update(ctx, preview)
+	}
+	logger = logger.WithValues("version", preview.Spec.Version, "storage", webapp.Spec.StorageName)
+	ctx = log.IntoContext(ctx, logger)
+
+	preview.Status.ExpiresAt = previewExpiration(preview)
+	if preview.Status.DeployedVersion != preview.Spec.Version {
+		deployCtx, cancel := context.WithTimeout(ctx, deploymentTimeout(webapp, r.DeploymentTimeout))
+		defer cancel()
+
+		observer := deploy.Observers{
+			eventObserver{recorder: r.Recorder, webapp: preview},
+			metricsObserver{webapp: client.ObjectKeyFromObject(webapp), preview: preview.Name},
+		}
+		err = deploy.DeployVersion(deployCtx, deploymentParameters, observer)
+		if err == nil {
+			err = deploy.VerifyDeployedVersion(deployCtx, deploymentParameters, observer)
+		}
+		if err != nil {
+			logger.Error(err, "Preview deployment failed")
+			r.Recorder.Eventf(preview, corev1.EventTypeWarning, reasonDeploymentFailed, "Unable to deploy version %s: %v", preview.Spec.Version, err)
+			meta.SetStatusCondition(&preview.Status.Conditions, v1.Condition{
+				Type:    conditionAvailable,
+				Status:  v1.ConditionFalse,
+				Reason:  interruptionReason(deployCtx),
+				Message: err.Error(),
+			})
+			preview.Status.Status = "ERROR"
+			if errStatusUpdate := r.Status().Update(ctx, preview); errStatusUpdate != nil {
+				return ctrl.Result{}, errStatusUpdate
+			}
+			return ctrl.Result{}, err
+		}
+
+		r.Recorder.Eventf(preview, corev1.EventTypeNormal, "Deployed", "Version %s deployed", preview.Spec.Version)
+	}
+
+	preview.Status.Status = "SUCCESS"
+	preview.Status.DeployedVersion = preview.Spec.Version
+	preview.Status.Url = previewUrl(deploymentParameters, webapp)
+	meta.SetStatusCondition(&preview.Status.Conditions, v1.Condition{
+		Type:    conditionAvailable,
+		Status:  v1.ConditionTrue,
+		Reason:  reasonDeployed,
+		Message: fmt.Sprintf("Version %s is available at %s", preview.Spec.Version, preview.Status.Url),
+	})
+	if err := r.Status().Update(ctx, preview); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if preview.Status.ExpiresAt != nil {
+		return ctrl.Result{RequeueAfter: time.Until(preview.Status.ExpiresAt.Time) + time.Second}, nil
+	}
+	return ctrl.Result{}, nil
+}
+
+// cleanup deletes the preview files then releases its finalizer.
+// Without the Webapp there are no credentials to delete the files with, they are left behind.
+func (r *WebappPreviewReconciler) cleanup(ctx context.Context, preview *webappv1alpha1.WebappPreview, webapp *webappv1alpha1.Webapp, webappFound bool) error {
+	logger := log.FromContext(ctx)
+	if !controllerutil.ContainsFinalizer(preview, webappv1alpha1.PreviewFinalizer) {
+		return nil
+	}
+
+	if !webappFound {
+		logger.Info("Webapp not found, the preview files are left behind", "webapp", preview.Spec.WebappName)
+		r.Recorder.Eventf(preview, corev1.EventTypeWarning, reasonCleanupSkipped, "Webapp %s not found, the preview files are left behind", preview.Spec.WebappName)
+	} else if deploymentParameters, err := previewParameters(preview, webapp, r.Endpoint, r.Dependencies); err == nil {
+		if err := r.checkPreviewLocation(ctx, webapp, deploymentParameters); err != nil {
+			// The location is deployed by a Webapp, deleting the files would break it
+			logger.Info("Preview location claimed by a Webapp, the preview files are left behind", "error", err.Error())
+			r.Recorder.Eventf(preview, corev1.EventTypeWarning, reasonCleanupSkipped, "The preview files are left behind: %v", err)
+		} else if err := deploy.DeleteDeployedFiles(ctx, deploymentParameters, deploy.Observers{
+			eventObserver{recorder: r.Recorder, webapp: preview},
+			metricsObserver{webapp: client.ObjectKeyFromObject(webapp), preview: preview.Name},
+		}); err != nil {
+			// The Cleanup step already published the failure
+			return err
+		}
+	}
+
+	controllerutil.RemoveFinalizer(preview, webappv1alpha1.PreviewFinalizer)
+	return r.Update(ctx, preview)
+}
+
+// previewParameters targets the Webapp deployment parameters on the preview folder or container
+func previewParameters(preview *webappv1alpha1.WebappPreview, webapp *webappv1alpha1.Webapp, defaultEndpoint webappv1alpha1.StorageEndpoint, dependencies *deploy.Dependencies) (deploy.Parameters, error) {
+	containerName, prefix := previewLocation(preview, webapp)
+	version := preview.Spec.Version
+	deploymentParameters := withVersionMarker(deploy.Parameters{
+		AzureCredential: &deploy.AzureCredential{
+			TenantId:  &webapp.Spec.AzureTenantId,
+			SpnId:     &webapp.Spec.AzureSpnId,
+			SpnSecret: &webapp.Spec.AzureSpnSecret,
+		},
+		StorageName:     &webapp.Spec.StorageName,
+		ContainerName:   &containerName,
+		FileNameToCheck: &webapp.Spec.FileNameToCheck,
+		BlobTagKey:      &webapp.Spec.BlobTagKey,
+		VersionToDeploy: &version,
+		Package: &deploy.Package{
+			StorageName:   &webapp.Spec.PackageStorageName,
+			ContainerName: &webapp.Spec.PackageContainerName,
+		},
+		Prefix:       &prefix,
+		Dependencies: dependencies,
+	}, webapp)
+	return withStorageAccess(deploymentParameters, webapp, defaultEndpoint), nil
+}
+
+// previewLocation returns the container and the prefix the preview is deployed to.
+// The cleanup deletes every file under the prefix, a preview never owns a whole container.
+func previewLocation(preview *webappv1alpha1.WebappPreview, webapp *webappv1alpha1.Webapp) (string, string) {
+	containerName := webapp.Spec.ContainerName
+	if preview.Spec.ContainerName != "" {
+		containerName = preview.Spec.ContainerName
+	}
+
+	prefix := strings.Trim(preview.Spec.Prefix, "/")
+	if prefix == "" {
+		prefix = "previews/" + preview.Name
+	}
+	return containerName, prefix + "/"
+}
+
+// checkPreviewLocation rejects the preview locations overlapping a location deployed by a Webapp, a blue/green slot or a
+// package container. Previews are only allowed in the folders of the root of their own Webapp container.
+func (r *WebappPreviewReconciler) checkPreviewLocation(ctx context.Context, webapp *webappv1alpha1.Webapp, deploymentParameters deploy.Parameters) error {
+	preview := newLocation(*deploymentParameters.StorageName, *deploymentParameters.ContainerName, deploymentParameters.BlobPrefix())
+	site := newLocation(webapp.Spec.StorageName, webapp.Spec.ContainerName, "")
+
+	webapps := &webappv1alpha1.WebappList{}
+	if err := r.List(ctx, webapps); err != nil {
+		return err
+	}
+	for i := range webapps.Items {
+		other := &webapps.Items[i]
+		for _, claimed := range claimedLocations(other) {
+			if other.UID == webapp.UID && claimed == site {
+				continue
+			}
+			if claimed.overlaps(preview) {
+				return fmt.Errorf("the preview location %s overlaps the location %s deployed by Webapp %s", preview, claimed, client.ObjectKeyFromObject(other))
+			}
+		}
+	}
+	return nil
+}
+
+// previewUrl returns the address of the preview: under the site URL when the preview is in the Webapp container,
+// the blob URL of its file to check otherwise
+func previewUrl(deploymentParameters deploy.Parameters, webapp *webappv1alpha1.Webapp) string {
+	if webapp.Spec.SiteUrl != "" && *deploymentParameters.ContainerName == webapp.Spec.ContainerName {
+		return strings.TrimSuffix(webapp.Spec.SiteUrl, "/") + "/" + deploymentParameters.BlobPrefix()
+	}
+	return deploymentParameters.StorageUrl() + deploymentParameters.BlobPrefix() + *deploymentParameters.FileNameToCheck
+}
+
+// previewExpiration returns the time the preview expires at, nil without TTL
+func previewExpiration(preview *webappv1alpha1.WebappPreview) *v1.Time {
+	if preview.Spec.Ttl == nil || preview.Spec.Ttl.Duration <= 0 {
+		return nil
+	}
+	expiresAt := v1.NewTime(preview.CreationTimestamp.Add(preview.Spec.Ttl.Duration))
+	return &expiresAt
+}
+
+func hasOwnerReference(object client.Object, owner client.Object) bool {
+	for _, reference := range object.GetOwnerReferences() {
+		if reference.UID == owner.GetUID() {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *WebappPreviewReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&webappv1alpha1.WebappPreview{}).
+		Watches(&source.Kind{Type: &webappv1alpha1.Webapp{}}, handler.EnqueueRequestsFromMapFunc(r.previewsOfWebapp)).
+		Complete(r)
+}
+
+// previewsOfWebapp enqueues the previews of a changed Webapp, e.g. suspended or resumed
+func (r *WebappPreviewReconciler) previewsOfWebapp(object client.Object) []reconcile.Request {
+	previews := &webappv1alpha1.WebappPreviewList{}
+	if err := r.List(context.Background(), previews, client.InNamespace(object.GetNamespace())); err != nil {
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, preview := range previews.Items {
+		if preview.Spec.WebappName == object.GetName() {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&preview)})
+		}
+	}
+	return requests
+}
@@ -0,0 +1,198 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	webappv1alpha1 "github.com/morganleroi/deploy-website-k8s-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sort"
+	"strings"
+	"time"
+)
+
+// errInvalidFreeze reports a freeze ConfigMap which can not be parsed, it blocks the deployments until it is fixed
+var errInvalidFreeze = errors.New("invalid freeze ConfigMap")
+
+// maxWindowLookups bounds the search of the next deployment time when windows and freezes overlap
+const maxWindowLookups = 64
+
+// freezePeriod is a period during which no deployment is allowed, declared in the freeze ConfigMap
+type freezePeriod struct {
+	name  string
+	start time.Time
+	end   time.Time
+}
+
+// interval is an occurrence of a deployment window
+type interval struct {
+	start time.Time
+	end   time.Time
+}
+
+// parseFreezePeriods reads the freeze ConfigMap entries, each value being a "start/end" RFC3339 range
+func parseFreezePeriods(configMap *corev1.ConfigMap) ([]freezePeriod, error) {
+	names := make([]string, 0, len(configMap.Data))
+	for name := range configMap.Data {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var freezes []freezePeriod
+	for _, name := range names {
+		bounds := strings.Split(strings.TrimSpace(configMap.Data[name]), "/")
+		if len(bounds) != 2 {
+			return nil, fmt.Errorf("invalid freeze %s: expecting a start/end range", name)
+		}
+		start, err := time.Parse(time.RFC3339, bounds[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid freeze %s start: %w", name, err)
+		}
+		end, err := time.Parse(time.RFC3339, bounds[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid freeze %s end: %w", name, err)
+		}
+		freezes = append(freezes, freezePeriod{name: name, start: start, end: end})
+	}
+	return freezes, nil
+}
+
+// windowOccurrences lists the occurrences of the windows from the day before t to a week after it.
+// A window ending before its start ends the next day.
+func windowOccurrences(windows []webappv1alpha1.DeploymentWindow, t time.Time) ([]interval, error) {
+	var occurrences []interval
+	for _, window := range windows {
+		location, err := time.LoadLocation(window.TimeZone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid deployment window time zone %q: %w", window.TimeZone, err)
+		}
+		start, err := time.Parse("15:04", window.Start)
+		if err != nil {
+			return nil, fmt.Errorf("invalid deployment window start %q: %w", window.Start, err)
+		}
+		end, err := time.Parse("15:04", window.End)
+		if err != nil {
+			return nil, fmt.Errorf("invalid deployment window end %q: %w", window.End, err)
+		}
+
+		local := t.In(location)
+		for offset := -1; offset <= 7; offset++ {
+			day := time.Date(local.Year(), local.Month(), local.Day()+offset, 0, 0, 0, 0, location)
+			if !windowIncludesDay(window, day.Weekday()) {
+				continue
+			}
+			occurrence := interval{
+				start: time.Date(day.Year(), day.Month(), day.Day(), start.Hour(), start.Minute(), 0, 0, location),
+				end:   time.Date(day.Year(), day.Month(), day.Day(), end.Hour(), end.Minute(), 0, 0, location),
+			}
+			if !occurrence.end.After(occurrence.start) {
+				occurrence.end = time.Date(day.Year(), day.Month(), day.Day()+1, end.Hour(), end.Minute(), 0, 0, location)
+			}
+			occurrences = append(occurrences, occurrence)
+		}
+	}
+	return occurrences, nil
+}
+
+func windowIncludesDay(window webappv1alpha1.DeploymentWindow, weekday time.Weekday) bool {
+	if len(window.Days) == 0 {
+		return true
+	}
+	for _, day := range window.Days {
+		if string(day) == weekday.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// nextDeploymentTime returns the first time from now at which a deployment is allowed, along with the reason
+// why it is not allowed now. The reason is empty when a deployment is allowed right away.
+func nextDeploymentTime(windows []webappv1alpha1.DeploymentWindow, freezes []freezePeriod, now time.Time) (time.Time, string, error) {
+	t := now
+	reason := ""
+	for i := 0; i < maxWindowLookups; i++ {
+		frozen := false
+		for _, freeze := range freezes {
+			if !t.Before(freeze.start) && t.Before(freeze.end) {
+				if reason == "" {
+					reason = fmt.Sprintf("Deployments are frozen by %s until %s", freeze.name, freeze.end.Format(time.RFC3339))
+				}
+				t = freeze.end
+				frozen = true
+			}
+		}
+		if frozen {
+			continue
+		}
+
+		if len(windows) == 0 {
+			return t, reason, nil
+		}
+		occurrences, err := windowOccurrences(windows, t)
+		if err != nil {
+			return time.Time{}, "", err
+		}
+		var next time.Time
+		for _, occurrence := range occurrences {
+			if !t.Before(occurrence.start) && t.Before(occurrence.end) {
+				return t, reason, nil
+			}
+			if occurrence.start.After(t) && (next.IsZero() || occurrence.start.Before(next)) {
+				next = occurrence.start
+			}
+		}
+		if next.IsZero() {
+			return time.Time{}, "", fmt.Errorf("no deployment window in the coming week")
+		}
+		if reason == "" {
+			reason = "Outside of the deployment windows"
+		}
+		t = next
+	}
+	return time.Time{}, "", fmt.Errorf("no deployment time found between the deployment windows and the freezes")
+}
+
+// freezePeriods returns the freezes declared in the freeze ConfigMap, if any
+func (r *WebappReconciler) freezePeriods(ctx context.Context) ([]freezePeriod, error) {
+	if r.FreezeConfigMap.Name == "" {
+		return nil, nil
+	}
+	configMap := &corev1.ConfigMap{}
+	if err := r.Get(ctx, r.FreezeConfigMap, configMap); err != nil {
+		return nil, client.IgnoreNotFound(err)
+	}
+	freezes, err := parseFreezePeriods(configMap)
+	if err != nil {
+		return nil, fmt.Errorf("%w %s: %v", errInvalidFreeze, r.FreezeConfigMap, err)
+	}
+	return freezes, nil
+}
+
+// webappsWaitingForWindow enqueues the Webapps waiting for a deployment window, or blocked by an invalid freeze,
+// when the freeze ConfigMap changes, so that lifting or fixing a freeze does not wait for the planned requeue
+func (r *WebappReconciler) webappsWaitingForWindow(object client.Object) []reconcile.Request {
+	if r.FreezeConfigMap != (types.NamespacedName{Namespace: object.GetNamespace(), Name: object.GetName()}) {
+		return nil
+	}
+
+	webapps := &webappv1alpha1.WebappList{}
+	if err := r.List(context.Background(), webapps); err != nil {
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, webapp := range webapps.Items {
+		degraded := meta.FindStatusCondition(webapp.Status.Conditions, conditionDegraded)
+		if meta.IsStatusConditionTrue(webapp.Status.Conditions, conditionWaitingForWindow) ||
+			(degraded != nil && degraded.Status == v1.ConditionTrue && degraded.Reason == reasonInvalidFreeze) {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&webapp)})
+		}
+	}
+	return requests
+}
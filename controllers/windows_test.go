@@ -0,0 +1,116 @@
+package controllers
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	webappv1alpha1 "github.com/morganleroi/deploy-website-k8s-operator/api/v1alpha1"
+)
+
+func date(day int, hour int, minute int) time.Time {
+	return time.Date(2026, time.January, day, hour, minute, 0, 0, time.UTC)
+}
+
+func TestNextDeploymentTime(t *testing.T) {
+	officeHours := webappv1alpha1.DeploymentWindow{Start: "09:00", End: "17:00"}
+	nights := webappv1alpha1.DeploymentWindow{Start: "22:00", End: "02:00"}
+	paris := webappv1alpha1.DeploymentWindow{Start: "09:00", End: "17:00", TimeZone: "Europe/Paris"}
+	mondays := webappv1alpha1.DeploymentWindow{Days: []webappv1alpha1.Weekday{"Monday"}, Start: "09:00", End: "17:00"}
+	freeze := freezePeriod{name: "release", start: date(5, 8, 0), end: date(5, 12, 0)}
+
+	for _, test := range []struct {
+		name    string
+		windows []webappv1alpha1.DeploymentWindow
+		freezes []freezePeriod
+		now     time.Time
+		next    time.Time
+		// reason is a part of the expected reason, empty when the deployment is allowed right away
+		reason string
+	}{
+		{"no windows", nil, nil, date(5, 3, 0), date(5, 3, 0), ""},
+		{"inside a window", []webappv1alpha1.DeploymentWindow{officeHours}, nil, date(5, 10, 0), date(5, 10, 0), ""},
+		{"before a window", []webappv1alpha1.DeploymentWindow{officeHours}, nil, date(5, 7, 0), date(5, 9, 0), "Outside"},
+		{"after a window", []webappv1alpha1.DeploymentWindow{officeHours}, nil, date(5, 17, 0), date(6, 9, 0), "Outside"},
+		{"after midnight in a window of the previous day", []webappv1alpha1.DeploymentWindow{nights}, nil, date(5, 1, 0), date(5, 1, 0), ""},
+		{"after a window wrapping around midnight", []webappv1alpha1.DeploymentWindow{nights}, nil, date(5, 3, 0), date(5, 22, 0), "Outside"},
+		{"time zone", []webappv1alpha1.DeploymentWindow{paris}, nil, date(5, 7, 30), date(5, 8, 0), "Outside"},
+		{"other day", []webappv1alpha1.DeploymentWindow{mondays}, nil, date(3, 10, 0), date(5, 9, 0), "Outside"},
+		{"frozen", nil, []freezePeriod{freeze}, date(5, 9, 0), date(5, 12, 0), "release"},
+		{"frozen then inside a window", []webappv1alpha1.DeploymentWindow{officeHours}, []freezePeriod{freeze}, date(5, 9, 0), date(5, 12, 0), "release"},
+		{"after a freeze", nil, []freezePeriod{freeze}, date(5, 12, 0), date(5, 12, 0), ""},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			next, reason, err := nextDeploymentTime(test.windows, test.freezes, test.now)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !next.Equal(test.next) {
+				t.Errorf("expecting %s, got %s", test.next, next)
+			}
+			if (test.reason == "") != (reason == "") || !strings.Contains(reason, test.reason) {
+				t.Errorf("expecting a reason containing %q, got %q", test.reason, reason)
+			}
+		})
+	}
+}
+
+func TestNextDeploymentTimeLookupsCutOff(t *testing.T) {
+	// Every window occurrence is frozen for more than maxWindowLookups days
+	var freezes []freezePeriod
+	for day := 1; day <= maxWindowLookups+10; day++ {
+		freezes = append(freezes, freezePeriod{name: "daily", start: date(day, 8, 0), end: date(day, 10, 30)})
+	}
+	windows := []webappv1alpha1.DeploymentWindow{{Start: "09:00", End: "10:00"}}
+
+	_, _, err := nextDeploymentTime(windows, freezes, date(1, 9, 0))
+	if err == nil || !strings.Contains(err.Error(), "no deployment time found") {
+		t.Fatalf("the search should stop after %d lookups, got %v", maxWindowLookups, err)
+	}
+}
+
+func TestWindowOccurrences(t *testing.T) {
+	occurrences, err := windowOccurrences([]webappv1alpha1.DeploymentWindow{{Start: "22:00", End: "02:00", TimeZone: "Europe/Paris"}}, date(5, 12, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// From the day before to a week after
+	if len(occurrences) != 9 {
+		t.Fatalf("expecting 9 occurrences, got %d", len(occurrences))
+	}
+	first := occurrences[0]
+	if !first.start.Equal(date(4, 21, 0)) || !first.end.Equal(date(5, 1, 0)) {
+		t.Errorf("the window should wrap around midnight in the time zone, got %s - %s", first.start, first.end)
+	}
+
+	if _, err := windowOccurrences([]webappv1alpha1.DeploymentWindow{{Start: "09:00", End: "17:00", TimeZone: "Mars/Olympus"}}, date(5, 12, 0)); err == nil {
+		t.Errorf("an unknown time zone should be rejected")
+	}
+	if _, err := windowOccurrences([]webappv1alpha1.DeploymentWindow{{Start: "9h", End: "17:00"}}, date(5, 12, 0)); err == nil {
+		t.Errorf("an invalid start should be rejected")
+	}
+}
+
+func TestParseFreezePeriods(t *testing.T) {
+	freezes, err := parseFreezePeriods(&corev1.ConfigMap{Data: map[string]string{
+		"summer":    "2026-08-01T00:00:00Z/2026-08-15T00:00:00Z",
+		"christmas": " 2026-12-20T00:00:00+01:00/2027-01-02T00:00:00+01:00\n",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(freezes) != 2 || freezes[0].name != "christmas" || freezes[1].name != "summer" {
+		t.Fatalf("expecting the freezes sorted by name, got %+v", freezes)
+	}
+	if !freezes[0].start.Equal(time.Date(2026, time.December, 19, 23, 0, 0, 0, time.UTC)) {
+		t.Errorf("the offset of the freeze should be kept, got %s", freezes[0].start)
+	}
+
+	for _, value := range []string{"2026-08-01T00:00:00Z", "2026-08-01/2026-08-15", "2026-08-01T00:00:00Z/tomorrow"} {
+		if _, err := parseFreezePeriods(&corev1.ConfigMap{Data: map[string]string{"invalid": value}}); err == nil {
+			t.Errorf("%q should be rejected", value)
+		}
+	}
+}
@@ -17,25 +17,45 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
 	"os"
+	"strings"
+	"time"
+	// Embed the time zone database, deployment windows are expressed in IANA time zones
+	_ "time/tzdata"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
 	webappv1alpha1 "github.com/morganleroi/deploy-website-k8s-operator/api/v1alpha1"
 	"github.com/morganleroi/deploy-website-k8s-operator/controllers"
+	"github.com/morganleroi/deploy-website-k8s-operator/controllers/trigger"
 	//+kubebuilder:scaffold:imports
 )
 
+// triggerBufferSize is the number of package notifications waiting for the controller before the receiver blocks
+const triggerBufferSize = 100
+
 var (
 	scheme   = runtime.NewScheme()
 	setupLog = ctrl.Log.WithName("setup")
@@ -52,11 +72,39 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var otlpEndpoint string
+	var otlpInsecure bool
+	var deploymentTimeout time.Duration
+	var maxConcurrentReconciles int
+	var freezeConfigMap string
+	var triggerAddr string
+	var cloud string
+	var blobEndpoint string
+	var blobPathStyle bool
+	var suspendAll bool
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8082", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&otlpEndpoint, "otlp-endpoint", "", "The OTLP/HTTP collector endpoint (host:port) traces are sent to. "+
+		"Tracing is disabled when empty.")
+	flag.BoolVar(&otlpInsecure, "otlp-insecure", false, "Send traces to the OTLP collector without TLS.")
+	flag.DurationVar(&deploymentTimeout, "deployment-timeout", controllers.DefaultDeploymentTimeout,
+		"The maximum duration of a deployment, a Webapp can override it with spec.deploymentTimeout.")
+	flag.IntVar(&maxConcurrentReconciles, "max-concurrent-reconciles", 1,
+		"The number of Webapps deployed in parallel. Webapps targeting the same container are never deployed simultaneously.")
+	flag.StringVar(&freezeConfigMap, "freeze-configmap", "",
+		"The namespace/name of the ConfigMap declaring the freeze periods, each value being a start/end RFC3339 range.")
+	flag.StringVar(&triggerAddr, "trigger-bind-address", "", "The address the package trigger receiver (Event Grid and webhooks) binds to. "+
+		"The receiver is disabled when empty, it requires the shared secret in the TRIGGER_SECRET environment variable.")
+	flag.StringVar(&cloud, "cloud", string(webappv1alpha1.CloudPublic), "The Azure cloud of the storage accounts (Public, China or USGov), "+
+		"a Webapp can override it with spec.endpoint.")
+	flag.StringVar(&blobEndpoint, "blob-endpoint", "", "A custom blob service endpoint replacing the cloud one, e.g. http://azurite:10000, "+
+		"a Webapp can override it with spec.endpoint.")
+	flag.BoolVar(&blobPathStyle, "blob-path-style", false, "Put the storage account name in the path of the custom blob endpoint instead of its host, as Azurite expects.")
+	flag.BoolVar(&suspendAll, "suspend-all", false, "Suspend the deployments of every Webapp, e.g. during an incident. "+
+		"The Webapps are deployed again from their latest spec once the operator is restarted without it.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -65,7 +113,38 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
+	var freezeConfigMapName types.NamespacedName
+	if freezeConfigMap != "" {
+		namespace, name, found := strings.Cut(freezeConfigMap, "/")
+		if !found || namespace == "" || name == "" {
+			setupLog.Error(nil, "invalid --freeze-configmap, expecting namespace/name", "freeze-configmap", freezeConfigMap)
+			os.Exit(1)
+		}
+		freezeConfigMapName = types.NamespacedName{Namespace: namespace, Name: name}
+	}
+
+	endpoint := webappv1alpha1.StorageEndpoint{
+		Cloud:        webappv1alpha1.Cloud(cloud),
+		BlobEndpoint: blobEndpoint,
+		PathStyle:    blobPathStyle,
+	}
+
+	ctx := ctrl.SetupSignalHandler()
+
+	if otlpEndpoint != "" {
+		shutdownTracing, err := setupTracing(ctx, otlpEndpoint, otlpInsecure)
+		if err != nil {
+			setupLog.Error(err, "unable to set up tracing")
+			os.Exit(1)
+		}
+		defer func() {
+			if err := shutdownTracing(context.Background()); err != nil {
+				setupLog.Error(err, "unable to flush traces")
+			}
+		}()
+	}
+
+	options := ctrl.Options{
 		Scheme:                 scheme,
 		MetricsBindAddress:     metricsAddr,
 		Port:                   9443,
@@ -83,19 +162,70 @@ func main() {
 		// if you are doing or is intended to do any operation such as perform cleanups
 		// after the manager stops then its usage might be unsafe.
 		// LeaderElectionReleaseOnCancel: true,
-	})
+	}
+	if freezeConfigMap != "" {
+		// Only the freeze ConfigMap is watched and cached, not every ConfigMap of the cluster
+		options.NewCache = cache.BuilderWithOptions(cache.Options{
+			SelectorsByObject: cache.SelectorsByObject{
+				&corev1.ConfigMap{}: {Field: fields.SelectorFromSet(fields.Set{
+					"metadata.namespace": freezeConfigMapName.Namespace,
+					"metadata.name":      freezeConfigMapName.Name,
+				})},
+			},
+		})
+	}
+	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), options)
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
-	if err = (&controllers.WebappReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
-	}).SetupWithManager(mgr); err != nil {
+	var triggers chan event.GenericEvent
+	reconciler := &controllers.WebappReconciler{
+		Client:                  mgr.GetClient(),
+		Scheme:                  mgr.GetScheme(),
+		Recorder:                mgr.GetEventRecorderFor("webapp-controller"),
+		DeploymentTimeout:       deploymentTimeout,
+		MaxConcurrentReconciles: maxConcurrentReconciles,
+		FreezeConfigMap:         freezeConfigMapName,
+		Endpoint:                endpoint,
+		SuspendAll:              suspendAll,
+	}
+	if triggerAddr != "" {
+		secret := os.Getenv("TRIGGER_SECRET")
+		if secret == "" {
+			setupLog.Error(nil, "the TRIGGER_SECRET environment variable is required by the trigger receiver")
+			os.Exit(1)
+		}
+		triggers = make(chan event.GenericEvent, triggerBufferSize)
+		reconciler.Triggers = triggers
+		if err = mgr.Add(&trigger.Receiver{
+			BindAddress: triggerAddr,
+			Secret:      []byte(secret),
+			Resolver:    reconciler,
+			NewObject:   controllers.NewTriggerObject,
+			Events:      triggers,
+			PathStyle:   blobPathStyle,
+		}); err != nil {
+			setupLog.Error(err, "unable to add the trigger receiver")
+			os.Exit(1)
+		}
+	}
+	if err = reconciler.SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Webapp")
 		os.Exit(1)
 	}
+	if err = (&controllers.WebappPreviewReconciler{
+		Client:            mgr.GetClient(),
+		Scheme:            mgr.GetScheme(),
+		Recorder:          mgr.GetEventRecorderFor("webapppreview-controller"),
+		DeploymentTimeout: deploymentTimeout,
+		Endpoint:          endpoint,
+		SuspendAll:        suspendAll,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "WebappPreview")
+		os.Exit(1)
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -108,8 +238,34 @@ func main() {
 	}
 
 	setupLog.Info("starting manager")
-	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
+	if err := mgr.Start(ctx); err != nil {
 		setupLog.Error(err, "problem running manager")
 		os.Exit(1)
 	}
 }
+
+// setupTracing registers a tracer provider exporting spans through OTLP/HTTP to the given endpoint (host:port).
+// The returned function flushes the pending spans and must be called before exiting.
+func setupTracing(ctx context.Context, endpoint string, insecure bool) (func(context.Context) error, error) {
+	options := []otlptracehttp.Option{otlptracehttp.WithEndpoint(endpoint)}
+	if insecure {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+
+	exporter, err := otlptracehttp.New(ctx, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceNameKey.String("deploy-website-k8s-operator"),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	return provider.Shutdown, nil
+}